@@ -0,0 +1,129 @@
+package Netpbm
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// Config describes an image from its header alone, without reading its pixels.
+type Config struct {
+	MagicNumber   string
+	Width, Height int
+	Depth         int      // samples per pixel: 1 for PBM and PGM, 3 for PPM, DEPTH for PAM
+	Max           int      // max value of the samples: 1 for PBM and 0 for PFM
+	TupleType     string   // PAM tuple type, empty for the other formats
+	Scale         float64  // PFM scale, whose sign gives the byte order; 0 for the other formats
+	Comments      []string // comments of the header, without their '#'
+}
+
+// config converts a header to a Config.
+func (h header) config() Config {
+	return Config{
+		MagicNumber: h.magicNumber,
+		Width:       h.width,
+		Height:      h.height,
+		Depth:       h.depth,
+		Max:         h.max,
+		TupleType:   h.tupleType,
+		Scale:       h.scale,
+		Comments:    h.comments,
+	}
+}
+
+// ColorModel returns the color model that image.Decode uses for images with this configuration.
+func (c Config) ColorModel() color.Model {
+	switch c.MagicNumber {
+	case "P1", "P4":
+		return color.GrayModel
+	case "P2", "P5":
+		return grayModel(uint16(c.Max))
+	case "P7":
+		// Same choice as the conversion done by PAM.image
+		switch {
+		case strings.HasSuffix(c.TupleType, "_ALPHA"):
+			return color.NRGBA64Model
+		case c.TupleType == TupleTypeRGB:
+			return color.RGBA64Model
+		}
+		return grayModel(uint16(c.Max))
+	}
+	return color.RGBA64Model
+}
+
+// ImageConfig converts the configuration to an image.Config.
+func (c Config) ImageConfig() image.Config {
+	return image.Config{ColorModel: c.ColorModel(), Width: c.Width, Height: c.Height}
+}
+
+// DecodePBMConfig reads the header of a PBM image without reading its pixels.
+func DecodePBMConfig(r io.Reader) (Config, error) {
+	h, err := newTokenizer(r, "PBM").readHeader("P1", "P4")
+	if err != nil {
+		return Config{}, err
+	}
+	return h.config(), nil
+}
+
+// DecodePGMConfig reads the header of a PGM image without reading its pixels.
+func DecodePGMConfig(r io.Reader) (Config, error) {
+	h, err := newTokenizer(r, "PGM").readHeader("P2", "P5")
+	if err != nil {
+		return Config{}, err
+	}
+	return h.config(), nil
+}
+
+// DecodePPMConfig reads the header of a PPM image without reading its pixels.
+func DecodePPMConfig(r io.Reader) (Config, error) {
+	h, err := newTokenizer(r, "PPM").readHeader("P3", "P6")
+	if err != nil {
+		return Config{}, err
+	}
+	return h.config(), nil
+}
+
+// DecodePAMConfig reads the header of a PAM image without reading its pixels.
+func DecodePAMConfig(r io.Reader) (Config, error) {
+	h, err := readPAMHeader(newTokenizer(r, "PAM"))
+	if err != nil {
+		return Config{}, err
+	}
+	return h.config(), nil
+}
+
+// DecodePFMConfig reads the header of a PFM image without reading its pixels.
+func DecodePFMConfig(r io.Reader) (Config, error) {
+	h, err := readPFMHeader(newTokenizer(r, "PFM"))
+	if err != nil {
+		return Config{}, err
+	}
+	return h.config(), nil
+}
+
+// DecodeConfig reads the header of a PBM, PGM, PPM, PAM or PFM image, detecting the format from its magic number.
+func DecodeConfig(r io.Reader) (Config, error) {
+	// Peek at the magic number without consuming it
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(2)
+	if err != nil {
+		return Config{}, newTokenizer(reader, "Netpbm").readError("magic number", err)
+	}
+
+	// Hand the whole header to the decoder of the detected format
+	switch string(magic) {
+	case "P1", "P4":
+		return DecodePBMConfig(reader)
+	case "P2", "P5":
+		return DecodePGMConfig(reader)
+	case "P3", "P6":
+		return DecodePPMConfig(reader)
+	case "P7":
+		return DecodePAMConfig(reader)
+	case "PF", "Pf":
+		return DecodePFMConfig(reader)
+	}
+	return Config{}, &ParseError{Format: "Netpbm", Line: 1, Field: "magic number", Value: string(magic), Err: ErrBadMagic}
+}
@@ -0,0 +1,92 @@
+package Netpbm
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"testing"
+)
+
+// Tests of DecodeConfig and of the configs registered with the image package.
+
+// testEncodings returns every format encoded from the test images, by magic number or tuple type.
+func testEncodings(t *testing.T) map[string][]byte {
+	t.Helper()
+	encodings := make(map[string][]byte)
+	encode := func(name string, encode func(w io.Writer) error) {
+		var buffer bytes.Buffer
+		if err := encode(&buffer); err != nil {
+			t.Fatal(err)
+		}
+		encodings[name] = buffer.Bytes()
+	}
+	for _, magicNumber := range []string{"P1", "P4"} {
+		pbm := testPBM(t, 11, 3, magicNumber)
+		encode(magicNumber, func(w io.Writer) error { return EncodePBM(w, pbm) })
+	}
+	for _, magicNumber := range []string{"P2", "P5"} {
+		pgm := testPGM(t, 11, 3, 1000, magicNumber)
+		encode(magicNumber, func(w io.Writer) error { return EncodePGM(w, pgm) })
+	}
+	for _, magicNumber := range []string{"P3", "P6"} {
+		ppm := testPPM(t, 11, 3, 255, magicNumber)
+		encode(magicNumber, func(w io.Writer) error { return EncodePPM(w, ppm) })
+	}
+	for _, pam := range []*PAM{
+		testPAM(11, 3, 1, 255, TupleTypeGrayscale),
+		testPAM(11, 3, 3, 255, TupleTypeRGB),
+		testPAM(11, 3, 4, 65535, TupleTypeRGBAlpha),
+	} {
+		encode("P7 "+pam.tupleType, func(w io.Writer) error { return EncodePAM(w, pam) })
+	}
+	for _, channels := range []int{1, 3} {
+		pfm := testPFM(t, 11, 3, channels)
+		encode(pfm.magicNumber, func(w io.Writer) error { return EncodePFM(w, pfm) })
+	}
+	return encodings
+}
+
+func TestImageDecodeConfig(t *testing.T) {
+	formats := map[string]string{"P1": "pbm", "P4": "pbm", "P2": "pgm", "P5": "pgm", "P3": "ppm", "P6": "ppm", "P7": "pam"}
+	for name, data := range testEncodings(t) {
+		// PFM images are not registered with the image package
+		if name == "PF" || name == "Pf" {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			config, format, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := formats[name[:2]]; format != want {
+				t.Errorf("format = %q, want %q", format, want)
+			}
+
+			// The config must describe the image that image.Decode returns
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != img.Bounds().Dx() || config.Height != img.Bounds().Dy() {
+				t.Errorf("size = %dx%d, want %v", config.Width, config.Height, img.Bounds().Size())
+			}
+			if config.ColorModel != img.ColorModel() {
+				t.Errorf("color model differs from the one of the %T image", img)
+			}
+		})
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	for name, data := range testEncodings(t) {
+		t.Run(name, func(t *testing.T) {
+			config, err := DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != 11 || config.Height != 3 || config.MagicNumber != name[:2] {
+				t.Errorf("%s image of size %dx%d, want %s of size 11x3", config.MagicNumber, config.Width, config.Height, name[:2])
+			}
+		})
+	}
+}
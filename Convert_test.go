@@ -0,0 +1,65 @@
+package Netpbm
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Tests of the conversions between formats, and of the threshold used when converting to PBM.
+
+func TestToPBMThreshold(t *testing.T) {
+	// Gray levels up to half the max value become black, the ones above become white
+	levels := []uint16{0, 127, 128, 255}
+	want := []uint16{1, 1, 0, 0}
+
+	pgm, err := NewPGM(len(levels), 1, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppm, err := NewPPM(len(levels), 1, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, level := range levels {
+		pgm.Set16(x, 0, level)
+		ppm.Set16(x, 0, Pixel16{level, level, level})
+	}
+
+	tests := []struct {
+		name string
+		pbm  *PBM
+	}{
+		{"PGM", pgm.ToPBM()},
+		{"PPM", ppm.ToPBM()},
+		{"PAM", pgm.ToPAM().ToPBM()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if samples := imageSamples(test.pbm); !reflect.DeepEqual(samples, want) {
+				t.Errorf("black pixels = %v, want %v", samples, want)
+			}
+		})
+	}
+}
+
+func TestConversionsToPBM(t *testing.T) {
+	// Converting a PBM image to another format and back keeps black pixels black
+	pbm := testPBM(t, 10, 3, "P1")
+	tests := []struct {
+		name string
+		pbm  *PBM
+	}{
+		{"through PGM", pbm.ToPGM().ToPBM()},
+		{"through PPM", pbm.ToPPM().ToPBM()},
+		{"through PAM", pbm.ToPAM().ToPBM()},
+		{"through PGM and PAM", pbm.ToPGM().ToPAM().ToPBM()},
+		{"through PPM and PAM", pbm.ToPPM().ToPAM().ToPBM()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if samples, want := imageSamples(test.pbm), imageSamples(pbm); !reflect.DeepEqual(samples, want) {
+				t.Errorf("pixels = %v, want %v", samples, want)
+			}
+		})
+	}
+}
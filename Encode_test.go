@@ -0,0 +1,177 @@
+package Netpbm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+// Benchmarks of the plain encoders on a Full HD image, written to an unbuffered
+// *os.File as Save used to do, so that the cost of each write call shows up.
+// The PerSample benchmarks time the former encoders, which made one fmt call
+// per sample straight to the file, to compare them with the current ones.
+
+const benchmarkWidth, benchmarkHeight = 1920, 1080
+
+// openDevNull opens the null device for writing, or stops the benchmark.
+func openDevNull(b *testing.B) *os.File {
+	file, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { file.Close() })
+	return file
+}
+
+// benchmarkPBM returns the PBM image encoded by the benchmarks.
+func benchmarkPBM(b *testing.B) *PBM {
+	pbm, err := NewPBM(benchmarkWidth, benchmarkHeight)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchmarkHeight; i++ {
+		for j := 0; j < benchmarkWidth; j++ {
+			pbm.Set(j, i, (i+j)%3 == 0)
+		}
+	}
+	return pbm
+}
+
+// benchmarkPGM returns the PGM image encoded by the benchmarks.
+func benchmarkPGM(b *testing.B) *PGM {
+	pgm, err := NewPGM(benchmarkWidth, benchmarkHeight, 255)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchmarkHeight; i++ {
+		for j := 0; j < benchmarkWidth; j++ {
+			pgm.Set(j, i, uint8(i*j))
+		}
+	}
+	return pgm
+}
+
+// benchmarkPPM returns the PPM image encoded by the benchmarks.
+func benchmarkPPM(b *testing.B) *PPM {
+	ppm, err := NewPPM(benchmarkWidth, benchmarkHeight, 255)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchmarkHeight; i++ {
+		for j := 0; j < benchmarkWidth; j++ {
+			ppm.Set(j, i, Pixel{uint8(i), uint8(j), uint8(i * j)})
+		}
+	}
+	return ppm
+}
+
+// encodePerSample writes a plain image the way the encoders did before they were
+// buffered: one fmt.Fprintf call per sample and one fmt.Fprintln call per row.
+func encodePerSample(w io.Writer, header string, samples int, sample func(x, y, k int) uint16) error {
+	if _, err := fmt.Fprint(w, header); err != nil {
+		return err
+	}
+	for i := 0; i < benchmarkHeight; i++ {
+		for j := 0; j < benchmarkWidth; j++ {
+			for k := 0; k < samples; k++ {
+				if _, err := fmt.Fprintf(w, "%d ", sample(j, i, k)); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// benchmarkEncode times encode, which writes an image to the null device.
+func benchmarkEncode(b *testing.B, encode func(w io.Writer) error) {
+	file := openDevNull(b)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := encode(file); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeP1(b *testing.B) {
+	pbm := benchmarkPBM(b)
+	benchmarkEncode(b, func(w io.Writer) error { return EncodePBM(w, pbm) })
+}
+
+func BenchmarkEncodeP1PerSample(b *testing.B) {
+	pbm := benchmarkPBM(b)
+	benchmarkEncode(b, func(w io.Writer) error {
+		return encodePerSample(w, "P1\n1920 1080\n", 1, func(x, y, k int) uint16 {
+			if pbm.At(x, y) {
+				return 1
+			}
+			return 0
+		})
+	})
+}
+
+func BenchmarkEncodeP2(b *testing.B) {
+	pgm := benchmarkPGM(b)
+	benchmarkEncode(b, func(w io.Writer) error { return EncodePGM(w, pgm) })
+}
+
+func BenchmarkEncodeP2PerSample(b *testing.B) {
+	pgm := benchmarkPGM(b)
+	benchmarkEncode(b, func(w io.Writer) error {
+		return encodePerSample(w, "P2\n1920 1080\n255\n", 1, func(x, y, k int) uint16 {
+			return pgm.At16(x, y)
+		})
+	})
+}
+
+func BenchmarkEncodeP3(b *testing.B) {
+	ppm := benchmarkPPM(b)
+	benchmarkEncode(b, func(w io.Writer) error { return EncodePPM(w, ppm) })
+}
+
+func BenchmarkEncodeP3PerSample(b *testing.B) {
+	ppm := benchmarkPPM(b)
+	benchmarkEncode(b, func(w io.Writer) error {
+		return encodePerSample(w, "P3\n1920 1080\n255\n", 3, func(x, y, k int) uint16 {
+			pixel := ppm.At16(x, y)
+			return [3]uint16{pixel.R, pixel.G, pixel.B}[k]
+		})
+	})
+}
+
+func TestEncodeForeignMagicNumber(t *testing.T) {
+	pbm, _ := NewPBM(2, 2)
+	pgm, _ := NewPGM(2, 2, 255)
+	ppm, _ := NewPPM(2, 2, 255)
+	tests := []struct {
+		name        string
+		img         Image
+		magicNumber string
+	}{
+		{"PBM as P2", pbm, "P2"},
+		{"PGM as P3", pgm, "P3"},
+		{"PGM as P6", pgm, "P6"},
+		{"PPM as P5", ppm, "P5"},
+		{"PPM unknown", ppm, "PX"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.img.SetMagicNumber(test.magicNumber)
+			var buffer bytes.Buffer
+			if err := NewEncoder(&buffer).Encode(test.img); !errors.Is(err, ErrBadMagic) {
+				t.Errorf("error = %v, want %v", err, ErrBadMagic)
+			}
+			// Not even the header is written
+			if buffer.Len() != 0 {
+				t.Errorf("wrote %q", buffer.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package Netpbm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors wrapped by *ParseError, to be tested with errors.Is.
+var (
+	// ErrBadMagic means the data does not start with the magic number of the expected format,
+	// or that a new image was given the magic number of another format.
+	ErrBadMagic = errors.New("bad magic number")
+	// ErrBadDimensions means the width, height or depth is missing, zero, negative or too large.
+	ErrBadDimensions = errors.New("bad dimensions")
+	// ErrBadMaxValue means the max value is not between 1 and 65535, or the PFM scale is invalid.
+	ErrBadMaxValue = errors.New("bad max value")
+	// ErrSyntax means a header token or a plain raster value is malformed.
+	ErrSyntax = errors.New("syntax error")
+	// ErrTruncated means the data ends before the image is complete, or that
+	// RowWriter.Close was called before every row was written.
+	ErrTruncated = errors.New("truncated image")
+	// ErrSampleOutOfRange means a sample is greater than the max value of the image.
+	ErrSampleOutOfRange = errors.New("sample out of range")
+	// ErrLimitExceeded means the header announces an image larger than the DecoderOptions allow,
+	// or too large to be held in memory at all.
+	ErrLimitExceeded = errors.New("decoder limit exceeded")
+)
+
+// ParseError describes malformed image data and where it was found.
+// Failures of the underlying reader are returned as they are, not as a *ParseError.
+type ParseError struct {
+	Format string // format being decoded: "PBM", "PGM", "PPM", "PAM" or "PFM"
+	Offset int64  // byte offset of the faulty data from the start of the image
+	Line   int    // line of the faulty data, starting at 1
+	Field  string // part of the image being read, such as "width" or "raster"
+	Value  string // faulty token, if any
+	Err    error  // one of the sentinel errors of this package
+}
+
+// Error returns a description of the error and its position.
+func (e *ParseError) Error() string {
+	message := fmt.Sprintf("netpbm: %s %s at line %d, offset %d: %v", e.Format, e.Field, e.Line, e.Offset, e.Err)
+	if e.Value != "" {
+		message += fmt.Sprintf(" (%q)", e.Value)
+	}
+	return message
+}
+
+// Unwrap returns the sentinel error, so that errors.Is(err, ErrTruncated) and the like work.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
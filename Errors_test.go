@@ -0,0 +1,90 @@
+package Netpbm
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Tests of the *ParseError values returned for malformed data: the sentinel
+// they wrap and the position they report.
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		err    error
+		field  string
+		offset int64
+		line   int
+	}{
+		{"wrong magic number", "P6 1 1 255\n", ErrBadMagic, "magic number", 0, 1},
+		{"zero width", "P2\n0 1\n255\n", ErrBadDimensions, "width", 3, 2},
+		{"height not a number", "P2\n2 x\n255\n", ErrSyntax, "height", 5, 2},
+		{"zero max value", "P2\n1 1\n0\n", ErrBadMaxValue, "max value", 7, 3},
+		{"max value above 65535", "P2 1 1 65536 0", ErrBadMaxValue, "max value", 7, 1},
+		{"sample above the max value", "P2\n2 1\n255\n1 300\n", ErrSampleOutOfRange, "raster", 13, 4},
+		{"bad P1 digit after comments", "P1\n# c\n2 # in\n 1\n1 2\n", ErrSyntax, "raster", 19, 5},
+		{"truncated plain raster", "P2\n2 2\n255\n1 2\n3", ErrTruncated, "raster", 16, 5},
+		{"truncated raw raster", "P5\n2 2\n255\n\x01\x02\x03", ErrTruncated, "raster", 14, 4},
+		{"missing height", "P2\n2", ErrTruncated, "height", 4, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodePGM(strings.NewReader(test.data))
+			if strings.HasPrefix(test.data, "P1") {
+				_, err = DecodePBM(strings.NewReader(test.data))
+			}
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("error %v is not a *ParseError", err)
+			}
+			if parseErr.Field != test.field || parseErr.Offset != test.offset || parseErr.Line != test.line {
+				t.Errorf("error at %s, offset %d, line %d; want %s, offset %d, line %d",
+					parseErr.Field, parseErr.Offset, parseErr.Line, test.field, test.offset, test.line)
+			}
+		})
+	}
+}
+
+func TestParseErrorMessage(t *testing.T) {
+	_, err := DecodePGM(strings.NewReader("P2\n2 x\n255\n"))
+	want := `netpbm: PGM height at line 2, offset 5: syntax error ("x")`
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %s", err, want)
+	}
+}
+
+// failingReader returns its error once its data has been read.
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestReaderErrorsAreNotParseErrors(t *testing.T) {
+	// Failures of the reader are returned as they are, not as malformed data
+	failure := errors.New("disk on fire")
+	_, err := DecodePPM(&failingReader{data: "P6 2 2 255\n\x01", err: failure})
+	var parseErr *ParseError
+	if !errors.Is(err, failure) || errors.As(err, &parseErr) {
+		t.Errorf("error = %#v, want %v", err, failure)
+	}
+	// The end of the data is a truncated image, not io.EOF
+	_, err = DecodePPM(&failingReader{data: "P6 2 2 255\n\x01", err: io.EOF})
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("error = %v, want %v", err, ErrTruncated)
+	}
+}
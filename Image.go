@@ -0,0 +1,397 @@
+package Netpbm
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// init registers the Netpbm formats with the image package, so that image.Decode
+// and image.DecodeConfig recognize them after a blank import of this package.
+func init() {
+	image.RegisterFormat("pbm", "P1", decodePBMImage, decodePBMConfig)
+	image.RegisterFormat("pbm", "P4", decodePBMImage, decodePBMConfig)
+	image.RegisterFormat("pgm", "P2", decodePGMImage, decodePGMConfig)
+	image.RegisterFormat("pgm", "P5", decodePGMImage, decodePGMConfig)
+	image.RegisterFormat("ppm", "P3", decodePPMImage, decodePPMConfig)
+	image.RegisterFormat("ppm", "P6", decodePPMImage, decodePPMConfig)
+	image.RegisterFormat("pam", "P7", decodePAMImage, decodePAMConfig)
+}
+
+// decodePBMImage decodes a PBM image for the image package.
+func decodePBMImage(r io.Reader) (image.Image, error) {
+	pbm, err := RegisteredDecoderOptions.DecodePBM(r)
+	if err != nil {
+		return nil, err
+	}
+	return pbm.Image(), nil
+}
+
+// decodePBMConfig reads the color model and dimensions from the header of a PBM image.
+func decodePBMConfig(r io.Reader) (image.Config, error) {
+	c, err := DecodePBMConfig(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return c.ImageConfig(), nil
+}
+
+// decodePGMImage decodes a PGM image for the image package.
+func decodePGMImage(r io.Reader) (image.Image, error) {
+	pgm, err := RegisteredDecoderOptions.DecodePGM(r)
+	if err != nil {
+		return nil, err
+	}
+	return pgm.Image(), nil
+}
+
+// decodePGMConfig reads the color model and dimensions from the header of a PGM image.
+func decodePGMConfig(r io.Reader) (image.Config, error) {
+	c, err := DecodePGMConfig(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return c.ImageConfig(), nil
+}
+
+// decodePPMImage decodes a PPM image for the image package.
+func decodePPMImage(r io.Reader) (image.Image, error) {
+	ppm, err := RegisteredDecoderOptions.DecodePPM(r)
+	if err != nil {
+		return nil, err
+	}
+	return ppm.Image(), nil
+}
+
+// decodePPMConfig reads the color model and dimensions from the header of a PPM image.
+func decodePPMConfig(r io.Reader) (image.Config, error) {
+	c, err := DecodePPMConfig(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return c.ImageConfig(), nil
+}
+
+// decodePAMImage decodes a PAM image for the image package.
+func decodePAMImage(r io.Reader) (image.Image, error) {
+	pam, err := RegisteredDecoderOptions.DecodePAM(r)
+	if err != nil {
+		return nil, err
+	}
+	return pam.image(), nil
+}
+
+// decodePAMConfig reads the color model and dimensions from the header of a PAM image.
+func decodePAMConfig(r io.Reader) (image.Config, error) {
+	c, err := DecodePAMConfig(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return c.ImageConfig(), nil
+}
+
+// grayModel returns the standard gray color model able to hold samples up to max.
+func grayModel(max uint16) color.Model {
+	if max > 255 {
+		return color.Gray16Model
+	}
+	return color.GrayModel
+}
+
+// scaleSample rescales a sample from the [0, max] range to the [0, to] range, rounding to the nearest value.
+func scaleSample(value, max uint16, to uint32) uint32 {
+	return (uint32(value)*to + uint32(max)/2) / uint32(max)
+}
+
+// unscaleSample rescales a 16-bit color channel to the [0, max] range, rounding to the nearest value.
+func unscaleSample(value uint32, max uint16) uint16 {
+	return uint16((value*uint32(max) + 0x7fff) / 0xffff)
+}
+
+// PBMImage exposes a PBM image through the image.Image and draw.Image interfaces.
+// Black pixels are color.Gray{0} and white pixels are color.Gray{255}.
+type PBMImage struct {
+	*PBM
+}
+
+// PGMImage exposes a PGM image through the image.Image and draw.Image interfaces,
+// with samples scaled from [0, max] to the full range of color.Gray or color.Gray16.
+type PGMImage struct {
+	*PGM
+}
+
+// PPMImage exposes a PPM image through the image.Image and draw.Image interfaces,
+// with samples scaled from [0, max] to the full range of color.RGBA64.
+type PPMImage struct {
+	*PPM
+}
+
+// Check at compile time that the adapters implement draw.Image.
+var (
+	_ draw.Image = PBMImage{}
+	_ draw.Image = PGMImage{}
+	_ draw.Image = PPMImage{}
+)
+
+// Image returns a view of the PBM image that implements draw.Image without copying pixels.
+func (pbm *PBM) Image() PBMImage {
+	return PBMImage{pbm}
+}
+
+// ColorModel returns color.GrayModel.
+func (pbm *PBM) ColorModel() color.Model {
+	return color.GrayModel
+}
+
+// Bounds returns the rectangle covering the PBM image.
+func (pbm *PBM) Bounds() image.Rectangle {
+	return image.Rect(0, 0, pbm.width, pbm.height)
+}
+
+// At returns the color of the pixel at the specified coordinates, or black outside the image.
+func (img PBMImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(img.Bounds())) || img.PBM.At(x, y) {
+		return color.Gray{0}
+	}
+	return color.Gray{0xff}
+}
+
+// Set sets the pixel at the specified coordinates to black when the color is darker than mid-gray.
+func (img PBMImage) Set(x, y int, c color.Color) {
+	// Pixels outside the image are ignored, as in the image package
+	if !(image.Point{x, y}.In(img.Bounds())) {
+		return
+	}
+	img.PBM.Set(x, y, color.GrayModel.Convert(c).(color.Gray).Y < 0x80)
+}
+
+// Image returns a view of the PGM image that implements draw.Image without copying pixels.
+func (pgm *PGM) Image() PGMImage {
+	return PGMImage{pgm}
+}
+
+// ColorModel returns color.GrayModel, or color.Gray16Model when max is above 255.
+func (pgm *PGM) ColorModel() color.Model {
+	return grayModel(pgm.max)
+}
+
+// Bounds returns the rectangle covering the PGM image.
+func (pgm *PGM) Bounds() image.Rectangle {
+	return image.Rect(0, 0, pgm.width, pgm.height)
+}
+
+// At returns the color of the pixel at the specified coordinates, or black outside the image.
+func (img PGMImage) At(x, y int) color.Color {
+	var value uint16
+	if (image.Point{x, y}.In(img.Bounds())) {
+		value = img.At16(x, y)
+	}
+	if img.max > 255 {
+		return color.Gray16{uint16(scaleSample(value, img.max, 0xffff))}
+	}
+	return color.Gray{uint8(scaleSample(value, img.max, 0xff))}
+}
+
+// Set sets the pixel at the specified coordinates to the gray level of the color.
+func (img PGMImage) Set(x, y int, c color.Color) {
+	// Pixels outside the image are ignored, as in the image package
+	if !(image.Point{x, y}.In(img.Bounds())) {
+		return
+	}
+	gray := color.Gray16Model.Convert(c).(color.Gray16)
+	img.Set16(x, y, unscaleSample(uint32(gray.Y), img.max))
+}
+
+// Gray returns an *image.Gray sharing the pixels of the PGM image, or nil unless the max value is 255.
+func (pgm *PGM) Gray() *image.Gray {
+	if pgm.max != 255 {
+		return nil
+	}
+	return &image.Gray{Pix: pgm.pix, Stride: pgm.stride, Rect: pgm.Bounds()}
+}
+
+// Gray16 returns an *image.Gray16 sharing the pixels of the PGM image, or nil unless the max value is 65535.
+func (pgm *PGM) Gray16() *image.Gray16 {
+	if pgm.max != 65535 {
+		return nil
+	}
+	return &image.Gray16{Pix: pgm.pix, Stride: pgm.stride, Rect: pgm.Bounds()}
+}
+
+// PGMFromGray creates a P5 PGM image with a max value of 255 that shares the pixels of img.
+func PGMFromGray(img *image.Gray) *PGM {
+	bounds := img.Bounds()
+	return &PGM{
+		pix:         img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y):],
+		stride:      img.Stride,
+		width:       bounds.Dx(),
+		height:      bounds.Dy(),
+		magicNumber: "P5",
+		max:         255,
+	}
+}
+
+// PGMFromGray16 creates a P5 PGM image with a max value of 65535 that shares the pixels of img.
+func PGMFromGray16(img *image.Gray16) *PGM {
+	bounds := img.Bounds()
+	return &PGM{
+		pix:         img.Pix[img.PixOffset(bounds.Min.X, bounds.Min.Y):],
+		stride:      img.Stride,
+		width:       bounds.Dx(),
+		height:      bounds.Dy(),
+		magicNumber: "P5",
+		max:         65535,
+	}
+}
+
+// Image returns a view of the PPM image that implements draw.Image without copying pixels.
+func (ppm *PPM) Image() PPMImage {
+	return PPMImage{ppm}
+}
+
+// ColorModel returns color.RGBA64Model.
+func (ppm *PPM) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+// Bounds returns the rectangle covering the PPM image.
+func (ppm *PPM) Bounds() image.Rectangle {
+	return image.Rect(0, 0, ppm.width, ppm.height)
+}
+
+// At returns the color of the pixel at the specified coordinates, or black outside the image.
+func (img PPMImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(img.Bounds())) {
+		return color.RGBA64{A: 0xffff}
+	}
+	pixel := img.At16(x, y)
+	return color.RGBA64{
+		R: uint16(scaleSample(pixel.R, img.max, 0xffff)),
+		G: uint16(scaleSample(pixel.G, img.max, 0xffff)),
+		B: uint16(scaleSample(pixel.B, img.max, 0xffff)),
+		A: 0xffff,
+	}
+}
+
+// Set sets the pixel at the specified coordinates to the color, ignoring its alpha channel.
+func (img PPMImage) Set(x, y int, c color.Color) {
+	// Pixels outside the image are ignored, as in the image package
+	if !(image.Point{x, y}.In(img.Bounds())) {
+		return
+	}
+	r, g, b, _ := c.RGBA()
+	img.Set16(x, y, Pixel16{unscaleSample(r, img.max), unscaleSample(g, img.max), unscaleSample(b, img.max)})
+}
+
+// image converts the PAM image to a standard image. Opaque gray images become gray images,
+// opaque RGB images become RGB images and images with an alpha channel become *image.NRGBA64.
+func (pam *PAM) image() image.Image {
+	if !pam.HasAlpha() {
+		if pam.tupleType == TupleTypeRGB {
+			return pam.ToPPM().Image()
+		}
+		return pam.ToPGM().Image()
+	}
+
+	// Alpha is not premultiplied in PAM, which matches the NRGBA family
+	img := image.NewNRGBA64(image.Rect(0, 0, pam.width, pam.height))
+	for y := 0; y < pam.height; y++ {
+		for x := 0; x < pam.width; x++ {
+			gray := uint16(scaleSample(pam.gray(x, y), pam.max, 0xffff))
+			tuple := pam.data[y][x*pam.depth : (x+1)*pam.depth]
+			c := color.NRGBA64{R: gray, G: gray, B: gray, A: uint16(scaleSample(tuple[pam.depth-1], pam.max, 0xffff))}
+			if pam.tupleType == TupleTypeRGBAlpha {
+				c.R = uint16(scaleSample(tuple[0], pam.max, 0xffff))
+				c.G = uint16(scaleSample(tuple[1], pam.max, 0xffff))
+				c.B = uint16(scaleSample(tuple[2], pam.max, 0xffff))
+			}
+			img.SetNRGBA64(x, y, c)
+		}
+	}
+	return img
+}
+
+// LumaFunc computes the gray level of a color from its 16-bit red, green and blue channels.
+// The result is in the [0, 0xffff] range.
+type LumaFunc func(r, g, b uint32) uint32
+
+// LumaRec601 weights the channels as ITU-R BT.601, like color.GrayModel.
+func LumaRec601(r, g, b uint32) uint32 {
+	return (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+}
+
+// LumaRec709 weights the channels as ITU-R BT.709.
+func LumaRec709(r, g, b uint32) uint32 {
+	return (13933*r + 46871*g + 4732*b + 1<<15) >> 16
+}
+
+// LumaAverage gives the same weight to each channel, like PPM.ToPGM.
+func LumaAverage(r, g, b uint32) uint32 {
+	return (r + g + b) / 3
+}
+
+// PPMFromImage creates a PPM image with the given max value and magic number from any image.
+// Transparent pixels are composited over black. It reports ErrBadMaxValue for a max value of 0
+// and ErrBadMagic for a magic number other than P3 and P6.
+func PPMFromImage(img image.Image, max uint16, magicNumber string) (*PPM, error) {
+	bounds := img.Bounds()
+	if err := checkImage(header{magicNumber: "P6", width: bounds.Dx(), height: bounds.Dy(), depth: 3, max: int(max)}, magicNumber, "P3", "P6"); err != nil {
+		return nil, err
+	}
+	ppm := newPPM(bounds.Dx(), bounds.Dy(), max, magicNumber)
+	// Scale each channel of each pixel to the max value
+	for i := 0; i < ppm.height; i++ {
+		for j := 0; j < ppm.width; j++ {
+			r, g, b, _ := img.At(bounds.Min.X+j, bounds.Min.Y+i).RGBA()
+			ppm.Set16(j, i, Pixel16{unscaleSample(r, max), unscaleSample(g, max), unscaleSample(b, max)})
+		}
+	}
+	return ppm, nil
+}
+
+// PGMFromImage creates a PGM image with the given max value and magic number from any image.
+// Colors are converted to gray levels with luma, which defaults to LumaRec601 when nil.
+// It reports ErrBadMaxValue for a max value of 0 and ErrBadMagic for a magic number other than P2 and P5.
+func PGMFromImage(img image.Image, max uint16, magicNumber string, luma LumaFunc) (*PGM, error) {
+	if luma == nil {
+		luma = LumaRec601
+	}
+	bounds := img.Bounds()
+	if err := checkImage(header{magicNumber: "P5", width: bounds.Dx(), height: bounds.Dy(), depth: 1, max: int(max)}, magicNumber, "P2", "P5"); err != nil {
+		return nil, err
+	}
+	pgm := newPGM(bounds.Dx(), bounds.Dy(), max, magicNumber)
+	// Scale the gray level of each pixel to the max value
+	for i := 0; i < pgm.height; i++ {
+		for j := 0; j < pgm.width; j++ {
+			r, g, b, _ := img.At(bounds.Min.X+j, bounds.Min.Y+i).RGBA()
+			pgm.Set16(j, i, unscaleSample(luma(r, g, b), max))
+		}
+	}
+	return pgm, nil
+}
+
+// PBMFromImage creates a PBM image with the given magic number from any image.
+// Colors are converted to gray levels with luma, which defaults to LumaRec601 when nil,
+// and pixels whose gray level is below threshold, between 0 and 1, become black.
+// It reports ErrBadMagic for a magic number other than P1 and P4.
+func PBMFromImage(img image.Image, magicNumber string, luma LumaFunc, threshold float64) (*PBM, error) {
+	if luma == nil {
+		luma = LumaRec601
+	}
+	bounds := img.Bounds()
+	if err := checkImage(header{magicNumber: "P4", width: bounds.Dx(), height: bounds.Dy(), max: 1}, magicNumber, "P1", "P4"); err != nil {
+		return nil, err
+	}
+	pbm := newPBM(bounds.Dx(), bounds.Dy(), magicNumber)
+	// Compare the gray level of each pixel with the threshold
+	limit := threshold * 0xffff
+	for i := 0; i < pbm.height; i++ {
+		for j := 0; j < pbm.width; j++ {
+			r, g, b, _ := img.At(bounds.Min.X+j, bounds.Min.Y+i).RGBA()
+			pbm.Set(j, i, float64(luma(r, g, b)) < limit)
+		}
+	}
+	return pbm, nil
+}
@@ -0,0 +1,223 @@
+package Netpbm
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+)
+
+// Tests of the conversions between Netpbm images and the image package.
+
+// testRGBA returns a 2x2 image with one black, one white, one red and one half-transparent pixel.
+func testRGBA() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(10, 20, 12, 22))
+	img.Set(10, 20, color.NRGBA{0, 0, 0, 255})
+	img.Set(11, 20, color.NRGBA{255, 255, 255, 255})
+	img.Set(10, 21, color.NRGBA{255, 0, 0, 255})
+	img.Set(11, 21, color.NRGBA{255, 255, 255, 128})
+	return img
+}
+
+func TestFromImage(t *testing.T) {
+	img := testRGBA()
+
+	ppm, err := PPMFromImage(img, 1000, "P6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		x, y int
+		want Pixel16
+	}{
+		{0, 0, Pixel16{0, 0, 0}},
+		{1, 0, Pixel16{1000, 1000, 1000}},
+		{0, 1, Pixel16{1000, 0, 0}},
+		{1, 1, Pixel16{502, 502, 502}}, // composited over black
+	} {
+		if pixel := ppm.At16(test.x, test.y); pixel != test.want {
+			t.Errorf("PPM pixel (%d, %d) = %v, want %v", test.x, test.y, pixel, test.want)
+		}
+	}
+
+	pgm, err := PGMFromImage(img, 255, "P2", LumaAverage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gray := []uint16{pgm.At16(0, 0), pgm.At16(1, 0), pgm.At16(0, 1)}; gray[0] != 0 || gray[1] != 255 || gray[2] != 85 {
+		t.Errorf("PGM gray levels = %v, want [0 255 85]", gray)
+	}
+
+	pbm, err := PBMFromImage(img, "P4", nil, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if black := []bool{pbm.At(0, 0), pbm.At(1, 0), pbm.At(0, 1)}; !black[0] || black[1] || !black[2] {
+		t.Errorf("PBM black pixels = %v, want [true false true]", black)
+	}
+}
+
+func TestFromImageErrors(t *testing.T) {
+	img := testRGBA()
+	tests := []struct {
+		name string
+		new  func() error
+		err  error
+	}{
+		{"PPM max value 0", func() error { _, err := PPMFromImage(img, 0, "P3"); return err }, ErrBadMaxValue},
+		{"PPM unknown magic number", func() error { _, err := PPMFromImage(img, 255, "PX"); return err }, ErrBadMagic},
+		{"PGM max value 0", func() error { _, err := PGMFromImage(img, 0, "P5", nil); return err }, ErrBadMaxValue},
+		{"PGM with a PPM magic number", func() error { _, err := PGMFromImage(img, 255, "P6", nil); return err }, ErrBadMagic},
+		{"PBM with a PGM magic number", func() error { _, err := PBMFromImage(img, "P2", nil, 0.5); return err }, ErrBadMagic},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.new(); !errors.Is(err, test.err) {
+				t.Errorf("error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDrawIntoAdapters(t *testing.T) {
+	// draw.Draw clips to the bounds of the destination, which starts at the origin
+	pbm, err := NewPBM(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := image.NewGray(image.Rect(0, 0, 3, 1))
+	gray.Pix = []uint8{0x00, 0x7f, 0x80}
+	draw.Draw(pbm.Image(), image.Rect(1, 1, 4, 2), gray, image.Point{}, draw.Src)
+
+	pgm, err := NewPGM(3, 2, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray16 := image.NewGray16(image.Rect(0, 0, 3, 1))
+	gray16.Pix = []uint8{0x00, 0x00, 0xff, 0xff, 0x80, 0x00}
+	draw.Draw(pgm.Image(), image.Rect(0, 1, 3, 2), gray16, image.Point{}, draw.Src)
+
+	ppm, err := NewPPM(2, 1, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	draw.Draw(ppm.Image(), ppm.Bounds(), testRGBA(), image.Point{10, 21}, draw.Src)
+
+	tests := []struct {
+		name    string
+		samples []uint16
+		want    []uint16
+	}{
+		// Colors darker than mid-gray become black
+		{"PBM", imageSamples(pbm), []uint16{0, 0, 0, 0, 1, 1}},
+		// Gray levels are scaled to the max value
+		{"PGM", imageSamples(pgm), []uint16{0, 0, 0, 0, 1000, 500}},
+		// draw.Src copies premultiplied colors; alpha itself is dropped
+		{"PPM", imageSamples(ppm), []uint16{255, 0, 0, 128, 128, 128}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.samples, test.want) {
+			t.Errorf("%s: samples = %v, want %v", test.name, test.samples, test.want)
+		}
+	}
+}
+
+func TestAdaptersOutsideBounds(t *testing.T) {
+	pbm, err := NewPBM(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgm, err := NewPGM(2, 2, 1000, WithPGMFill(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppm, err := NewPPM(2, 2, 255, WithPPMFill(Pixel16{255, 255, 255}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		img   draw.Image
+		color color.Color
+	}{
+		{"PBM", pbm.Image(), color.Gray{0}},
+		{"PGM", pgm.Image(), color.Gray16{0}},
+		{"PPM", ppm.Image(), color.RGBA64{A: 0xffff}},
+	}
+	for _, test := range tests {
+		// Pixels outside the image read as black and writing them does nothing
+		for _, p := range []image.Point{{-1, 0}, {0, -1}, {2, 0}, {0, 2}} {
+			test.img.Set(p.X, p.Y, color.White)
+			if c := test.img.At(p.X, p.Y); c != test.color {
+				t.Errorf("%s: color at %v = %v, want %v", test.name, p, c, test.color)
+			}
+		}
+	}
+}
+
+func TestGraySharesPixels(t *testing.T) {
+	pgm, err := NewPGM(3, 2, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := pgm.Gray()
+	gray.SetGray(1, 0, color.Gray{7})
+	pgm.Set16(0, 1, 9)
+	if pgm.At16(1, 0) != 7 || gray.GrayAt(0, 1).Y != 9 {
+		t.Errorf("PGM and Gray do not share their pixels")
+	}
+
+	pgm16, err := NewPGM(3, 2, 65535)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray16 := pgm16.Gray16()
+	gray16.SetGray16(2, 1, color.Gray16{0x1234})
+	pgm16.Set16(0, 0, 0xfedc)
+	if pgm16.At16(2, 1) != 0x1234 || gray16.Gray16At(0, 0).Y != 0xfedc {
+		t.Errorf("PGM and Gray16 do not share their pixels")
+	}
+
+	// Other max values have no standard image with the same layout
+	pgm1000, err := NewPGM(3, 2, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pgm1000.Gray() != nil || pgm1000.Gray16() != nil || pgm16.Gray() != nil || pgm.Gray16() != nil {
+		t.Error("Gray or Gray16 shares pixels of another max value")
+	}
+}
+
+func TestPGMFromGraySubImage(t *testing.T) {
+	// A sub-image keeps the stride of the whole image, and starts at its own origin
+	base := image.NewGray(image.Rect(0, 0, 4, 3))
+	for i := range base.Pix {
+		base.Pix[i] = uint8(i)
+	}
+	pgm := PGMFromGray(base.SubImage(image.Rect(1, 1, 3, 3)).(*image.Gray))
+	if width, height := pgm.Size(); width != 2 || height != 2 {
+		t.Fatalf("size = %dx%d, want 2x2", width, height)
+	}
+	pgm.Set16(1, 1, 100)
+	base.SetGray(1, 1, color.Gray{200})
+	if base.GrayAt(2, 2).Y != 100 || pgm.At16(0, 0) != 200 {
+		t.Error("PGM and Gray do not share their pixels")
+	}
+	var buffer bytes.Buffer
+	if err := EncodePGM(&buffer, pgm); err != nil {
+		t.Fatal(err)
+	}
+	if want := "P5\n2 2\n255\n\xc8\x06\x09\x64"; buffer.String() != want {
+		t.Errorf("encoded %q, want %q", buffer.String(), want)
+	}
+
+	base16 := image.NewGray16(image.Rect(0, 0, 4, 3))
+	pgm16 := PGMFromGray16(base16.SubImage(image.Rect(2, 1, 4, 2)).(*image.Gray16))
+	pgm16.Set16(1, 0, 0xabcd)
+	if pgm16.max != 65535 || base16.Gray16At(3, 1).Y != 0xabcd {
+		t.Error("PGM and Gray16 do not share their pixels")
+	}
+}
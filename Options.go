@@ -0,0 +1,382 @@
+package Netpbm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ImageOption configures an image created by NewPBM, NewPGM or NewPPM.
+type ImageOption func(*imageOptions)
+
+// PBMOption configures an image created by NewPBM: an ImageOption or WithPBMFill.
+type PBMOption interface {
+	applyPBM(*imageOptions)
+}
+
+// PGMOption configures an image created by NewPGM: an ImageOption or WithPGMFill.
+type PGMOption interface {
+	applyPGM(*imageOptions)
+}
+
+// PPMOption configures an image created by NewPPM: an ImageOption or WithPPMFill.
+type PPMOption interface {
+	applyPPM(*imageOptions)
+}
+
+func (opt ImageOption) applyPBM(o *imageOptions) { opt(o) }
+func (opt ImageOption) applyPGM(o *imageOptions) { opt(o) }
+func (opt ImageOption) applyPPM(o *imageOptions) { opt(o) }
+
+// imageOptions holds the settings collected from the options.
+type imageOptions struct {
+	magicNumber string
+	comments    []string
+	bitFill     bool
+	sampleFill  uint16
+	pixelFill   Pixel16
+}
+
+// WithMagicNumber sets the magic number of the new image, such as "P4" for a raw PBM image.
+func WithMagicNumber(magicNumber string) ImageOption {
+	return func(o *imageOptions) {
+		o.magicNumber = magicNumber
+	}
+}
+
+// WithComments sets the comment lines written in the header of the new image.
+func WithComments(comments ...string) ImageOption {
+	return func(o *imageOptions) {
+		o.comments = append(o.comments, comments...)
+	}
+}
+
+// pbmFill is the option returned by WithPBMFill.
+type pbmFill bool
+
+// WithPBMFill sets the value of every pixel of a new PBM image: true for black.
+func WithPBMFill(value bool) PBMOption {
+	return pbmFill(value)
+}
+
+func (f pbmFill) applyPBM(o *imageOptions) { o.bitFill = bool(f) }
+
+// pgmFill is the option returned by WithPGMFill.
+type pgmFill uint16
+
+// WithPGMFill sets the sample of every pixel of a new PGM image, stored like Set16 does.
+// It must not exceed the max value of the image.
+func WithPGMFill(value uint16) PGMOption {
+	return pgmFill(value)
+}
+
+func (f pgmFill) applyPGM(o *imageOptions) { o.sampleFill = uint16(f) }
+
+// ppmFill is the option returned by WithPPMFill.
+type ppmFill Pixel16
+
+// WithPPMFill sets the samples of every pixel of a new PPM image, stored like Set16 does.
+// They must not exceed the max value of the image.
+func WithPPMFill(value Pixel16) PPMOption {
+	return ppmFill(value)
+}
+
+func (f ppmFill) applyPPM(o *imageOptions) { o.pixelFill = Pixel16(f) }
+
+// checkImage checks the settings of a new image before it is created: the size of
+// the image described by a raw format header, its max value and its magic number,
+// which must be one of valid.
+func checkImage(h header, magicNumber string, valid ...string) error {
+	size, ok := h.imageSize()
+	if h.width < 0 || h.height < 0 || !ok || size > math.MaxInt {
+		return fmt.Errorf("netpbm: cannot create a %dx%d image: %w", h.width, h.height, ErrBadDimensions)
+	}
+	// A max value of 0 leaves no sample to scale pixels to
+	if h.max == 0 {
+		return fmt.Errorf("netpbm: cannot create an image with a max value of 0: %w", ErrBadMaxValue)
+	}
+	return checkMagicNumber("create", magicNumber, valid...)
+}
+
+// checkMagicNumber reports ErrBadMagic when magicNumber is not one of valid, the magic numbers
+// of a single format; action says what could not be done with the image, such as "encode".
+func checkMagicNumber(action, magicNumber string, valid ...string) error {
+	for _, v := range valid {
+		if magicNumber == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("netpbm: cannot %s a %s image with the magic number %q: %w", action, formatName(valid[0]), magicNumber, ErrBadMagic)
+}
+
+// NewPBM creates a white PBM image of the given size, in the plain P1 format by default.
+// It reports ErrBadDimensions for negative dimensions and ErrBadMagic for a magic number other than P1 and P4.
+func NewPBM(width, height int, opts ...PBMOption) (*PBM, error) {
+	o := imageOptions{magicNumber: "P1"}
+	for _, opt := range opts {
+		opt.applyPBM(&o)
+	}
+	if err := checkImage(header{magicNumber: "P4", width: width, height: height, max: 1}, o.magicNumber, "P1", "P4"); err != nil {
+		return nil, err
+	}
+
+	// Create the pixel buffer
+	pbm := newPBM(width, height, o.magicNumber)
+	pbm.comments = o.comments
+	if o.bitFill {
+		pbm.Invert()
+	}
+	return pbm, nil
+}
+
+// NewPGM creates a black PGM image of the given size and max value, in the plain P2 format by default.
+// It reports ErrBadDimensions for negative dimensions, ErrBadMaxValue for a max value of 0,
+// ErrBadMagic for a magic number other than P2 and P5 and ErrSampleOutOfRange for a fill above the max value.
+func NewPGM(width, height int, max uint16, opts ...PGMOption) (*PGM, error) {
+	o := imageOptions{magicNumber: "P2"}
+	for _, opt := range opts {
+		opt.applyPGM(&o)
+	}
+	if err := checkImage(header{magicNumber: "P5", width: width, height: height, depth: 1, max: int(max)}, o.magicNumber, "P2", "P5"); err != nil {
+		return nil, err
+	}
+	if o.sampleFill > max {
+		return nil, fmt.Errorf("netpbm: fill %d above max value %d: %w", o.sampleFill, max, ErrSampleOutOfRange)
+	}
+
+	// Create the pixel buffer
+	pgm := newPGM(width, height, max, o.magicNumber)
+	pgm.comments = o.comments
+	if o.sampleFill != 0 {
+		for i := 0; i < height; i++ {
+			for j := 0; j < width; j++ {
+				pgm.Set16(j, i, o.sampleFill)
+			}
+		}
+	}
+	return pgm, nil
+}
+
+// NewPPM creates a black PPM image of the given size and max value, in the plain P3 format by default.
+// It reports ErrBadDimensions for negative dimensions, ErrBadMaxValue for a max value of 0,
+// ErrBadMagic for a magic number other than P3 and P6 and ErrSampleOutOfRange for a fill above the max value.
+func NewPPM(width, height int, max uint16, opts ...PPMOption) (*PPM, error) {
+	o := imageOptions{magicNumber: "P3"}
+	for _, opt := range opts {
+		opt.applyPPM(&o)
+	}
+	if err := checkImage(header{magicNumber: "P6", width: width, height: height, depth: 3, max: int(max)}, o.magicNumber, "P3", "P6"); err != nil {
+		return nil, err
+	}
+	fill := o.pixelFill
+	if fill.R > max || fill.G > max || fill.B > max {
+		return nil, fmt.Errorf("netpbm: fill %v above max value %d: %w", fill, max, ErrSampleOutOfRange)
+	}
+
+	// Create the pixel buffer
+	ppm := newPPM(width, height, max, o.magicNumber)
+	ppm.comments = o.comments
+	if fill != (Pixel16{}) {
+		for i := 0; i < height; i++ {
+			for j := 0; j < width; j++ {
+				ppm.Set16(j, i, fill)
+			}
+		}
+	}
+	return ppm, nil
+}
+
+// NewPFM creates a black PFM image of the given size with 1 channel (Pf) or 3 channels (PF).
+// Its samples are written little-endian with a scale of 1, as those of PGM.ToPFM and PPM.ToPFM.
+// It reports ErrBadDimensions for negative dimensions and ErrBadMagic for another number of channels.
+func NewPFM(width, height, channels int) (*PFM, error) {
+	// The number of channels gives the magic number
+	var magicNumber string
+	switch channels {
+	case 1:
+		magicNumber = "Pf"
+	case 3:
+		magicNumber = "PF"
+	default:
+		return nil, fmt.Errorf("netpbm: cannot create a PFM image with %d channels: %w", channels, ErrBadMagic)
+	}
+	if err := checkImage(header{magicNumber: magicNumber, width: width, height: height, depth: channels, max: 1}, magicNumber, magicNumber); err != nil {
+		return nil, err
+	}
+
+	// Create the rows of samples
+	data := make([][]float32, height)
+	for i := range data {
+		data[i] = make([]float32, width*channels)
+	}
+	// A scale of -1 means little-endian samples with no extra scaling
+	return &PFM{data: data, width: width, height: height, channels: channels, scale: -1, magicNumber: magicNumber}, nil
+}
+
+// DecoderOptions controls the decoders. Its limits bound the resources they use and are checked
+// against the header before any pixel memory is allocated, so that a tiny file
+// announcing huge dimensions is rejected with ErrLimitExceeded. Zero means no limit.
+type DecoderOptions struct {
+	MaxWidth  int   // largest accepted width
+	MaxHeight int   // largest accepted height
+	MaxPixels int64 // largest accepted width × height
+	MaxBytes  int64 // largest accepted size of the decoded pixels in memory
+
+	// Logger, when set, receives debug events: format detected, dimensions,
+	// comments read and bytes consumed.
+	Logger *slog.Logger
+}
+
+// RegisteredDecoderOptions holds the limits of the decoders registered with the
+// image package, which image.Decode uses without any way to pass options. By
+// default an image may take up to 1 GiB in memory there, so that a small upload
+// announcing huge dimensions cannot exhaust it. The package-level functions of
+// this package, such as DecodePGM and Read, have no limits.
+var RegisteredDecoderOptions = &DecoderOptions{MaxBytes: 1 << 30}
+
+// checkRowLimits reports ErrLimitExceeded when the image described by the header exceeds the limits
+// of a RowReader, which only keeps one row in memory: MaxPixels and MaxBytes bound that row.
+func (o *DecoderOptions) checkRowLimits(tok *tokenizer, h header) error {
+	if o.MaxHeight > 0 && h.height > o.MaxHeight {
+		return tok.fail("height", strconv.Itoa(h.height), ErrLimitExceeded)
+	}
+	row := h
+	row.height = 1
+	return o.checkLimits(tok, row)
+}
+
+// checkLimits reports ErrLimitExceeded when the image described by the header exceeds the limits.
+func (o *DecoderOptions) checkLimits(tok *tokenizer, h header) error {
+	pixels, pixelsOK := multiply(int64(h.width), int64(h.height))
+	size, sizeOK := h.imageSize()
+	switch {
+	case !pixelsOK || !sizeOK || size > math.MaxInt:
+		// The pixels would not even fit in memory
+		return tok.fail("pixels", fmt.Sprintf("%dx%dx%d", h.width, h.height, h.depth), ErrLimitExceeded)
+	case o.MaxWidth > 0 && h.width > o.MaxWidth:
+		return tok.fail("width", strconv.Itoa(h.width), ErrLimitExceeded)
+	case o.MaxHeight > 0 && h.height > o.MaxHeight:
+		return tok.fail("height", strconv.Itoa(h.height), ErrLimitExceeded)
+	case o.MaxPixels > 0 && pixels > o.MaxPixels:
+		return tok.fail("pixels", strconv.FormatInt(pixels, 10), ErrLimitExceeded)
+	case o.MaxBytes > 0 && size > o.MaxBytes:
+		return tok.fail("bytes", strconv.FormatInt(size, 10), ErrLimitExceeded)
+	}
+	return nil
+}
+
+// EncoderOptions controls the layout of the images written by the encoders.
+// The zero value follows the Netpbm specification: plain raster lines are
+// wrapped at 70 characters and values are separated by single spaces.
+type EncoderOptions struct {
+	// MaxLineLength is the longest plain raster line, newline excluded. Zero means 70,
+	// as the specification asks; a negative value writes each row on a single line.
+	MaxLineLength int
+	// Separator is written between plain raster values. It must be made of spaces
+	// and tabs; empty means a single space.
+	Separator string
+	// TrailingSpace ends every plain raster value with the separator, including
+	// the last one of each line, as older versions of this package did.
+	TrailingSpace bool
+	// Comments, when not nil, replaces the comments of the image in the header;
+	// an empty slice writes no comments at all.
+	Comments []string
+}
+
+// separator returns the separator written between plain raster values.
+func (o *EncoderOptions) separator() (string, error) {
+	if o.Separator == "" {
+		return " ", nil
+	}
+	// Newlines would break the accounting of line lengths
+	if strings.Trim(o.Separator, " \t") != "" {
+		return "", fmt.Errorf("netpbm: separator %q is not made of spaces and tabs", o.Separator)
+	}
+	return o.Separator, nil
+}
+
+// comments returns the comments to write in the header of an image that has the given ones.
+func (o *EncoderOptions) comments(comments []string) []string {
+	if o.Comments != nil {
+		return o.Comments
+	}
+	return comments
+}
+
+// plainRow lays out the values of plain raster rows on lines, following the encoder options.
+type plainRow struct {
+	maxLineLength int
+	separator     string
+	trailingSpace bool
+	buffer        []byte // encoded lines, waiting to be written
+	length        int    // length of the current line
+}
+
+// newPlainRow creates a plainRow following the options.
+func (o *EncoderOptions) newPlainRow() (*plainRow, error) {
+	separator, err := o.separator()
+	if err != nil {
+		return nil, err
+	}
+	maxLineLength := o.MaxLineLength
+	if maxLineLength == 0 {
+		maxLineLength = 70
+	}
+	return &plainRow{maxLineLength: maxLineLength, separator: separator, trailingSpace: o.TrailingSpace}, nil
+}
+
+// appendUint adds a decimal value to the row, starting a new line when it would not fit on the current one.
+func (p *plainRow) appendUint(value uint64) {
+	// The separator comes before the value, or after it when trailing spaces are asked for
+	start := len(p.buffer)
+	if p.length > 0 && !p.trailingSpace {
+		p.buffer = append(p.buffer, p.separator...)
+	}
+	p.buffer = strconv.AppendUint(p.buffer, value, 10)
+	if p.trailingSpace {
+		p.buffer = append(p.buffer, p.separator...)
+	}
+	if p.maxLineLength <= 0 || p.length == 0 || p.length+len(p.buffer)-start <= p.maxLineLength {
+		p.length += len(p.buffer) - start
+		return
+	}
+
+	// The value does not fit: move it to a new line, without the separator that came before it
+	from := start
+	if !p.trailingSpace {
+		from += len(p.separator)
+	}
+	n := len(p.buffer) - from
+	p.buffer = append(p.buffer, 0)
+	copy(p.buffer[start+1:], p.buffer[from:from+n])
+	p.buffer[start] = '\n'
+	p.buffer = p.buffer[:start+1+n]
+	p.length = n
+}
+
+// endRow ends the current line, so that every image row starts on a new line.
+func (p *plainRow) endRow() {
+	p.buffer = append(p.buffer, '\n')
+	p.length = 0
+}
+
+// flush writes the encoded lines to w and empties the buffer.
+func (p *plainRow) flush(w io.Writer) error {
+	_, err := w.Write(p.buffer)
+	p.buffer = p.buffer[:0]
+	return err
+}
+
+// encodeBuffered runs encode on a buffer in front of w, so that the many small
+// writes of an encoder reach w in large chunks, and flushes it to report any write error.
+func encodeBuffered(w io.Writer, encode func(w io.Writer) error) error {
+	buffered := bufio.NewWriter(w)
+	if err := encode(buffered); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
@@ -0,0 +1,248 @@
+package Netpbm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests of the constructors, of the decoder limits against headers announcing
+// huge images, of the events sent to the logger, and of the layouts chosen by the encoder options.
+
+func TestDecoderLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		options DecoderOptions
+		err     error
+	}{
+		{"within the limits", "P5 2 2 255\n\x01\x02\x03\x04", DecoderOptions{MaxWidth: 2, MaxHeight: 2, MaxPixels: 4, MaxBytes: 4}, nil},
+		{"width", "P5 3 2 255\n", DecoderOptions{MaxWidth: 2}, ErrLimitExceeded},
+		{"height", "P2 2 3 255\n", DecoderOptions{MaxHeight: 2}, ErrLimitExceeded},
+		{"pixels", "P3 3 4 255\n", DecoderOptions{MaxPixels: 11}, ErrLimitExceeded},
+		{"bytes of 16-bit samples", "P5 2 2 65535\n", DecoderOptions{MaxBytes: 7}, ErrLimitExceeded},
+		{"bytes of packed bits", "P4 16 1\n\xff\xff", DecoderOptions{MaxBytes: 2}, nil},
+		{"bytes of packed bits over", "P4 17 1\n", DecoderOptions{MaxBytes: 2}, ErrLimitExceeded},
+		{"default limits", "P6 100000 100000 255\n", *RegisteredDecoderOptions, ErrLimitExceeded},
+		{"pixels overflowing an int64", "P6 4611686018427387904 4 255\n", DecoderOptions{}, ErrLimitExceeded},
+		{"bytes overflowing an int64", "P6 3037000500 3037000500 65535\n", DecoderOptions{}, ErrLimitExceeded},
+		{"packed rows overflowing an int64", "P4 9223372036854775807 2\n", DecoderOptions{}, ErrLimitExceeded},
+		{"huge PAM depth", "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 4611686018427387904\nMAXVAL 255\nENDHDR\n", DecoderOptions{MaxPixels: 10}, ErrLimitExceeded},
+		{"PAM depth over the cap", "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 4097\nMAXVAL 255\nENDHDR\n", DecoderOptions{}, ErrLimitExceeded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			switch test.data[1] {
+			case '7':
+				_, err = test.options.DecodePAM(strings.NewReader(test.data))
+			default:
+				_, err = test.options.Decode(strings.NewReader(test.data))
+			}
+			if !errors.Is(err, test.err) {
+				t.Errorf("error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestImageDecodeLimits(t *testing.T) {
+	// The decoders registered with the image package use the default limits
+	_, _, err := image.Decode(strings.NewReader("P5\n100000 100000\n255\n"))
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("error = %v, want %v", err, ErrLimitExceeded)
+	}
+}
+
+func TestRowReaderLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		options DecoderOptions
+		err     error
+	}{
+		{"gigapixel image, small rows", "P6 40000 40000 255\n", DecoderOptions{MaxPixels: 40000, MaxBytes: 1 << 20}, nil},
+		{"row over MaxBytes", "P6 40000 2 255\n", DecoderOptions{MaxBytes: 119999}, ErrLimitExceeded},
+		{"row over MaxPixels", "P2 40000 2 255\n", DecoderOptions{MaxPixels: 39999}, ErrLimitExceeded},
+		{"height", "P4 8 40000\n", DecoderOptions{MaxHeight: 39999}, ErrLimitExceeded},
+		{"row overflowing an int64", "P6 4611686018427387904 1 65535\n", DecoderOptions{}, ErrLimitExceeded},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.options.NewRowReader(strings.NewReader(test.data)); !errors.Is(err, test.err) {
+				t.Errorf("error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+// eventHandler is a slog.Handler recording the message and the attributes of every event.
+type eventHandler struct {
+	events []string
+	attrs  []map[string]string
+}
+
+func (h *eventHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *eventHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *eventHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *eventHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := make(map[string]string)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.String()
+		return true
+	})
+	h.events = append(h.events, r.Message)
+	h.attrs = append(h.attrs, attrs)
+	return nil
+}
+
+func TestDecoderLogger(t *testing.T) {
+	data := "P2\n# made by hand\n2 1\n9\n1 2\n"
+	want := []string{"netpbm: comment read", "netpbm: format detected", "netpbm: dimensions", "netpbm: image decoded"}
+	tests := []struct {
+		name   string
+		decode func(o *DecoderOptions) error
+	}{
+		{"Decode", func(o *DecoderOptions) error {
+			_, err := o.Decode(strings.NewReader(data))
+			return err
+		}},
+		{"RowReader", func(o *DecoderOptions) error {
+			rr, err := o.NewRowReader(strings.NewReader(data))
+			if err != nil {
+				return err
+			}
+			return rr.ReadGrayRow(make([]uint16, 2))
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := &eventHandler{}
+			if err := test.decode(&DecoderOptions{Logger: slog.New(handler)}); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(handler.events, want) {
+				t.Fatalf("events = %q, want %q", handler.events, want)
+			}
+			if attrs := handler.attrs[1]; attrs["format"] != "PGM" || attrs["magic"] != "P2" {
+				t.Errorf("format detected with %v, want PGM and P2", attrs)
+			}
+			if attrs := handler.attrs[2]; attrs["width"] != "2" || attrs["height"] != "1" || attrs["max"] != "9" {
+				t.Errorf("dimensions with %v, want 2x1 and max 9", attrs)
+			}
+			if attrs := handler.attrs[3]; attrs["comments"] != "1" {
+				t.Errorf("image decoded with %v, want 1 comment", attrs)
+			}
+		})
+	}
+}
+
+func TestNewImage(t *testing.T) {
+	pbm, err := NewPBM(3, 2, WithPBMFill(true), WithMagicNumber("P4"), WithComments("a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samples := imageSamples(pbm); !reflect.DeepEqual(samples, []uint16{1, 1, 1, 1, 1, 1}) || pbm.magicNumber != "P4" {
+		t.Errorf("PBM %s pixels = %v, want all black", pbm.magicNumber, samples)
+	}
+
+	pgm, err := NewPGM(2, 1, 1000, WithPGMFill(999), WithComments("a"), WithComments("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samples := imageSamples(pgm); !reflect.DeepEqual(samples, []uint16{999, 999}) || pgm.magicNumber != "P2" {
+		t.Errorf("PGM %s samples = %v, want 999", pgm.magicNumber, samples)
+	}
+	if comments := pgm.Comments(); !reflect.DeepEqual(comments, []string{"a", "b"}) {
+		t.Errorf("PGM comments = %q", comments)
+	}
+
+	ppm, err := NewPPM(1, 1, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samples := imageSamples(ppm); !reflect.DeepEqual(samples, []uint16{0, 0, 0}) || ppm.magicNumber != "P3" {
+		t.Errorf("PPM %s samples = %v, want black", ppm.magicNumber, samples)
+	}
+}
+
+func TestNewImageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func() error
+		err  error
+	}{
+		{"empty PBM", func() error { _, err := NewPBM(0, 0); return err }, nil},
+		{"negative PBM width", func() error { _, err := NewPBM(-1, 2); return err }, ErrBadDimensions},
+		{"negative PGM height", func() error { _, err := NewPGM(2, -1, 255); return err }, ErrBadDimensions},
+		{"oversized PPM", func() error { _, err := NewPPM(1<<62, 4, 65535); return err }, ErrBadDimensions},
+		{"PGM max value 0", func() error { _, err := NewPGM(2, 2, 0); return err }, ErrBadMaxValue},
+		{"PPM max value 0", func() error { _, err := NewPPM(2, 2, 0); return err }, ErrBadMaxValue},
+		{"PBM raw magic number", func() error { _, err := NewPBM(2, 2, WithMagicNumber("P4")); return err }, nil},
+		{"PGM with a PPM magic number", func() error { _, err := NewPGM(2, 2, 255, WithMagicNumber("P3")); return err }, ErrBadMagic},
+		{"PPM unknown magic number", func() error { _, err := NewPPM(2, 2, 255, WithMagicNumber("PX")); return err }, ErrBadMagic},
+		{"PGM fill", func() error { _, err := NewPGM(2, 2, 255, WithPGMFill(128)); return err }, nil},
+		{"PGM fill above max", func() error { _, err := NewPGM(2, 2, 100, WithPGMFill(128)); return err }, ErrSampleOutOfRange},
+		{"PPM fill above max", func() error { _, err := NewPPM(2, 2, 100, WithPPMFill(Pixel16{0, 101, 0})); return err }, ErrSampleOutOfRange},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.new(); !errors.Is(err, test.err) {
+				t.Errorf("error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestEncoderOptions(t *testing.T) {
+	pgm, err := NewPGM(5, 2, 255, WithComments("keep"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		pgm.Set16(i%5, i/5, uint16(i*25))
+	}
+	tests := []struct {
+		name    string
+		options EncoderOptions
+		want    string
+	}{
+		{"specification", EncoderOptions{}, "P2\n# keep\n5 2\n255\n0 25 50 75 100\n125 150 175 200 225\n"},
+		{"short lines", EncoderOptions{MaxLineLength: 8}, "P2\n# keep\n5 2\n255\n0 25 50\n75 100\n125 150\n175 200\n225\n"},
+		{"tabs, one row per line", EncoderOptions{MaxLineLength: -1, Separator: "\t"}, "P2\n# keep\n5 2\n255\n0\t25\t50\t75\t100\n125\t150\t175\t200\t225\n"},
+		{"trailing spaces", EncoderOptions{MaxLineLength: 9, TrailingSpace: true}, "P2\n# keep\n5 2\n255\n0 25 50 \n75 100 \n125 150 \n175 200 \n225 \n"},
+		{"no comments", EncoderOptions{Comments: []string{}}, "P2\n5 2\n255\n0 25 50 75 100\n125 150 175 200 225\n"},
+		{"other comments", EncoderOptions{Comments: []string{"new"}}, "P2\n# new\n5 2\n255\n0 25 50 75 100\n125 150 175 200 225\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			if err := test.options.EncodePGM(&buffer, pgm); err != nil {
+				t.Fatal(err)
+			}
+			if buffer.String() != test.want {
+				t.Errorf("encoded %q, want %q", buffer.String(), test.want)
+			}
+			// Every layout decodes back to the same samples
+			decoded, err := DecodePGM(&buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(imageSamples(decoded), imageSamples(pgm)) {
+				t.Errorf("decoded samples = %v", imageSamples(decoded))
+			}
+		})
+	}
+
+	// Separators that would break lines are refused
+	if err := (&EncoderOptions{Separator: " \n"}).EncodePGM(io.Discard, pgm); err == nil {
+		t.Error("no error for a separator holding a newline")
+	}
+}
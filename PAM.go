@@ -0,0 +1,432 @@
+package Netpbm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Standard PAM tuple types.
+const (
+	TupleTypeBlackAndWhite      = "BLACKANDWHITE"
+	TupleTypeGrayscale          = "GRAYSCALE"
+	TupleTypeRGB                = "RGB"
+	TupleTypeBlackAndWhiteAlpha = "BLACKANDWHITE_ALPHA"
+	TupleTypeGrayscaleAlpha     = "GRAYSCALE_ALPHA"
+	TupleTypeRGBAlpha           = "RGB_ALPHA"
+)
+
+type PAM struct {
+	data                 [][]uint16
+	width, height, depth int
+	max                  uint16
+	tupleType            string
+	comments             []string
+}
+
+// ReadPAM reads a PAM image from a file and returns a struct that represents the image.
+func ReadPAM(filename string) (*PAM, error) {
+	// Open the file for reading
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err // Return an error if file opening fails
+	}
+	defer file.Close()
+
+	// Decode the image from the file contents
+	return DecodePAM(file)
+}
+
+// DecodePAM reads a PAM image from r and returns a struct that represents the image.
+func DecodePAM(r io.Reader) (*PAM, error) {
+	// Decode without any resource limit
+	return (&DecoderOptions{}).DecodePAM(r)
+}
+
+// DecodePAM reads a PAM image from r within the limits of the options.
+func (o *DecoderOptions) DecodePAM(r io.Reader) (*PAM, error) {
+	// Create a tokenizer so that both header lines and raw bytes can be read
+	tok := o.newTokenizer(r, "PAM")
+
+	// Read the header up to ENDHDR
+	h, err := readPAMHeader(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	tok.logHeader(h)
+
+	// Check the dimensions against the limits before allocating the pixels
+	if err := o.checkLimits(tok, h); err != nil {
+		return nil, err
+	}
+	width, height, depth, maxValue := h.width, h.height, h.depth, h.max
+
+	// Read the raw samples, one byte each or two big-endian bytes when the max value is above 255
+	data := make([][]uint16, height)
+	row := make([]byte, width*depth*sampleSize(uint16(maxValue)))
+	for i := range data {
+		if err := tok.readFull(row); err != nil {
+			return nil, err
+		}
+		data[i] = make([]uint16, width*depth)
+		for j := range data[i] {
+			if data[i][j], err = tok.rawSample(row, j, uint16(maxValue)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	tok.logDone()
+
+	// Create a new instance of the PAM structure
+	return &PAM{
+		data:      data,
+		width:     width,
+		height:    height,
+		depth:     depth,
+		max:       uint16(maxValue),
+		tupleType: h.tupleType,
+		comments:  tok.comments,
+	}, nil
+}
+
+// maxPAMDepth is the largest DEPTH accepted in a PAM header. Real tuple types
+// use at most a handful of samples, so larger depths only come from broken
+// or hostile files.
+const maxPAMDepth = 4096
+
+// readPAMHeader reads the header of a PAM image, from the magic number to ENDHDR.
+func readPAMHeader(tok *tokenizer) (header, error) {
+	// Read the first line to determine the format
+	line, err := tok.readLine("magic number")
+	if err != nil {
+		return header{}, err
+	}
+	if strings.TrimSpace(line) != "P7" {
+		return header{}, tok.fail("magic number", line, ErrBadMagic)
+	}
+
+	// Read the header lines until ENDHDR
+	var width, height, depth, maxValue int
+	var tupleTypes []string
+	for {
+		line, err = tok.readLine("header")
+		if err != nil {
+			return header{}, err
+		}
+		line = strings.TrimSpace(line)
+		// Skip blank lines and comment lines
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			tok.comment(line[1:])
+			continue
+		}
+		if line == "ENDHDR" {
+			break
+		}
+
+		// Every other header line is a keyword followed by its value
+		fields := strings.Fields(line)
+		keyword, value := fields[0], strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		switch keyword {
+		case "WIDTH":
+			width, err = strconv.Atoi(value)
+		case "HEIGHT":
+			height, err = strconv.Atoi(value)
+		case "DEPTH":
+			depth, err = strconv.Atoi(value)
+		case "MAXVAL":
+			maxValue, err = strconv.Atoi(value)
+		case "TUPLTYPE":
+			// Several TUPLTYPE lines are concatenated with a space
+			tupleTypes = append(tupleTypes, value)
+		default:
+			return header{}, tok.fail("header", line, ErrSyntax)
+		}
+		if err != nil {
+			return header{}, tok.fail(keyword, value, ErrSyntax)
+		}
+	}
+	tupleType := strings.Join(tupleTypes, " ")
+
+	// Check that the header is complete and consistent
+	if width < 1 || height < 1 || depth < 1 {
+		return header{}, tok.fail("header", fmt.Sprintf("%dx%dx%d", width, height, depth), ErrBadDimensions)
+	}
+	if depth > maxPAMDepth {
+		return header{}, tok.fail("DEPTH", strconv.Itoa(depth), ErrLimitExceeded)
+	}
+	if maxValue < 1 || maxValue > 65535 {
+		return header{}, tok.fail("MAXVAL", strconv.Itoa(maxValue), ErrBadMaxValue)
+	}
+	if expected := tupleDepth(tupleType); expected != 0 && expected != depth {
+		return header{}, tok.fail("DEPTH", strconv.Itoa(depth), ErrBadDimensions)
+	}
+
+	return header{magicNumber: "P7", width: width, height: height, depth: depth, max: maxValue, tupleType: tupleType, comments: tok.comments}, nil
+}
+
+// Size returns the width and height of the PAM image.
+func (pam *PAM) Size() (int, int) {
+	return pam.width, pam.height
+}
+
+// Depth returns the number of samples in each tuple of the PAM image.
+func (pam *PAM) Depth() int {
+	return pam.depth
+}
+
+// TupleType returns the tuple type of the PAM image, such as RGB_ALPHA.
+func (pam *PAM) TupleType() string {
+	return pam.tupleType
+}
+
+// Comments returns a copy of the comment lines of the PAM image, without their '#'.
+func (pam *PAM) Comments() []string {
+	return append([]string(nil), pam.comments...)
+}
+
+// SetComments replaces the comment lines written after the magic number of the PAM image.
+func (pam *PAM) SetComments(comments []string) {
+	pam.comments = append([]string(nil), comments...)
+}
+
+// HasAlpha reports whether the last sample of each tuple is an alpha channel.
+func (pam *PAM) HasAlpha() bool {
+	return strings.HasSuffix(pam.tupleType, "_ALPHA")
+}
+
+// At retrieves a copy of the tuple of a pixel at the specified coordinates in the PAM image.
+func (pam *PAM) At(x, y int) []uint16 {
+	// Copy the samples so that the caller cannot modify the image by accident
+	tuple := make([]uint16, pam.depth)
+	copy(tuple, pam.data[y][x*pam.depth:(x+1)*pam.depth])
+	return tuple
+}
+
+// Set sets the tuple of a pixel at the specified coordinates in the PAM image.
+func (pam *PAM) Set(x, y int, tuple []uint16) {
+	// Only the first depth samples of the tuple are used
+	copy(pam.data[y][x*pam.depth:(x+1)*pam.depth], tuple)
+}
+
+// Save saves the PAM image to a file with the specified filename.
+func (pam *PAM) Save(filename string) error {
+	// Write the image to a temporary file that replaces the destination once complete
+	return saveFile(filename, func(w io.Writer) error {
+		return EncodePAM(w, pam)
+	})
+}
+
+// EncodePAM writes the PAM image to w.
+func EncodePAM(w io.Writer, pam *PAM) error {
+	return encodeBuffered(w, func(w io.Writer) error {
+		return encodePAM(w, pam)
+	})
+}
+
+// encodePAM writes the PAM image to the buffered writer w.
+func encodePAM(w io.Writer, pam *PAM) error {
+	// Write the PAM header
+	if _, err := fmt.Fprint(w, "P7\n"); err != nil {
+		return err
+	}
+	if err := writeComments(w, pam.comments); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "WIDTH %d\nHEIGHT %d\nDEPTH %d\nMAXVAL %d\n", pam.width, pam.height, pam.depth, pam.max)
+	if err != nil {
+		return err
+	}
+	if pam.tupleType != "" {
+		if _, err := fmt.Fprintf(w, "TUPLTYPE %s\n", pam.tupleType); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprint(w, "ENDHDR\n"); err != nil {
+		return err
+	}
+
+	// Write each row as raw samples
+	row := make([]byte, 0, pam.width*pam.depth*sampleSize(pam.max))
+	for i := 0; i < pam.height; i++ {
+		row = row[:0]
+		for _, sample := range pam.data[i] {
+			row = appendSample(row, sample, pam.max)
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invert inverts the color samples of the PAM image, leaving the alpha channel untouched.
+func (pam *PAM) Invert() {
+	// Number of color samples in each tuple
+	channels := pam.depth
+	if pam.HasAlpha() {
+		channels--
+	}
+	for i := 0; i < pam.height; i++ {
+		for j := 0; j < pam.width; j++ {
+			for k := 0; k < channels; k++ {
+				pam.data[i][j*pam.depth+k] = pam.max - pam.data[i][j*pam.depth+k]
+			}
+		}
+	}
+}
+
+// Flip vertically flips the PAM image.
+func (pam *PAM) Flip() {
+	// Swap whole tuples from both ends of each row
+	for _, row := range pam.data {
+		for i, j := 0, pam.width-1; i < j; i, j = i+1, j-1 {
+			for k := 0; k < pam.depth; k++ {
+				row[i*pam.depth+k], row[j*pam.depth+k] = row[j*pam.depth+k], row[i*pam.depth+k]
+			}
+		}
+	}
+}
+
+// Flop horizontally flips the PAM image.
+func (pam *PAM) Flop() {
+	// Swap the rows from top to bottom
+	for i, j := 0, len(pam.data)-1; i < j; i, j = i+1, j-1 {
+		pam.data[i], pam.data[j] = pam.data[j], pam.data[i]
+	}
+}
+
+// AddAlpha appends a fully opaque alpha channel to a BLACKANDWHITE, GRAYSCALE or RGB image.
+func (pam *PAM) AddAlpha() {
+	// Nothing to do if the image already has an alpha channel
+	if pam.HasAlpha() {
+		return
+	}
+	// Rebuild each row with one more sample per tuple
+	for i, row := range pam.data {
+		alpha := make([]uint16, 0, pam.width*(pam.depth+1))
+		for j := 0; j < pam.width; j++ {
+			alpha = append(alpha, row[j*pam.depth:(j+1)*pam.depth]...)
+			alpha = append(alpha, pam.max)
+		}
+		pam.data[i] = alpha
+	}
+	pam.depth++
+	pam.tupleType += "_ALPHA"
+}
+
+// gray returns the gray level of the pixel at the specified coordinates, averaging the color samples.
+func (pam *PAM) gray(x, y int) uint16 {
+	tuple := pam.data[y][x*pam.depth : (x+1)*pam.depth]
+	if strings.HasPrefix(pam.tupleType, TupleTypeRGB) && pam.depth >= 3 {
+		return uint16((uint32(tuple[0]) + uint32(tuple[1]) + uint32(tuple[2])) / 3)
+	}
+	return tuple[0]
+}
+
+// ToPPM converts the PAM image to a PPM image, dropping the alpha channel.
+func (pam *PAM) ToPPM() *PPM {
+	// Create a new instance of the PPM structure
+	ppm := newPPM(pam.width, pam.height, pam.max, "P3")
+	for i := 0; i < pam.height; i++ {
+		for j := 0; j < pam.width; j++ {
+			// Copy RGB samples, or replicate the gray level on the three channels
+			if strings.HasPrefix(pam.tupleType, TupleTypeRGB) && pam.depth >= 3 {
+				tuple := pam.data[i][j*pam.depth:]
+				ppm.Set16(j, i, Pixel16{tuple[0], tuple[1], tuple[2]})
+			} else {
+				gray := pam.gray(j, i)
+				ppm.Set16(j, i, Pixel16{gray, gray, gray})
+			}
+		}
+	}
+	return ppm
+}
+
+// ToPGM converts the PAM image to a PGM image, dropping the alpha channel.
+func (pam *PAM) ToPGM() *PGM {
+	// Create a new instance of the PGM structure
+	pgm := newPGM(pam.width, pam.height, pam.max, "P2")
+	for i := 0; i < pam.height; i++ {
+		for j := 0; j < pam.width; j++ {
+			pgm.Set16(j, i, pam.gray(j, i))
+		}
+	}
+	return pgm
+}
+
+// ToPBM converts the PAM image to a PBM image, where dark pixels become black.
+func (pam *PAM) ToPBM() *PBM {
+	// Create a new instance of the PBM structure
+	pbm := newPBM(pam.width, pam.height, "P1")
+	for i := 0; i < pam.height; i++ {
+		for j := 0; j < pam.width; j++ {
+			// A pixel is black when its gray level is in the lower half of the range, as in PGM.ToPBM
+			pbm.Set(j, i, pam.gray(j, i) <= pam.max/2)
+		}
+	}
+	return pbm
+}
+
+// ToPAM converts the PPM image to an RGB PAM image.
+func (ppm *PPM) ToPAM() *PAM {
+	// Interleave the three samples of each pixel in the PAM rows
+	data := make([][]uint16, ppm.height)
+	for i := range data {
+		data[i] = make([]uint16, 0, 3*ppm.width)
+		for j := 0; j < ppm.width; j++ {
+			pixel := ppm.At16(j, i)
+			data[i] = append(data[i], pixel.R, pixel.G, pixel.B)
+		}
+	}
+	return &PAM{data: data, width: ppm.width, height: ppm.height, depth: 3, max: ppm.max, tupleType: TupleTypeRGB}
+}
+
+// ToPAM converts the PGM image to a GRAYSCALE PAM image.
+func (pgm *PGM) ToPAM() *PAM {
+	// Copy each row of samples
+	data := make([][]uint16, pgm.height)
+	for i := range data {
+		data[i] = make([]uint16, pgm.width)
+		for j := range data[i] {
+			data[i][j] = pgm.At16(j, i)
+		}
+	}
+	return &PAM{data: data, width: pgm.width, height: pgm.height, depth: 1, max: pgm.max, tupleType: TupleTypeGrayscale}
+}
+
+// ToPAM converts the PBM image to a BLACKANDWHITE PAM image.
+func (pbm *PBM) ToPAM() *PAM {
+	// In a BLACKANDWHITE PAM image 0 is black and 1 is white, the opposite of PBM
+	data := make([][]uint16, pbm.height)
+	for i := range data {
+		data[i] = make([]uint16, pbm.width)
+		for j := range data[i] {
+			if !pbm.At(j, i) {
+				data[i][j] = 1
+			}
+		}
+	}
+	return &PAM{data: data, width: pbm.width, height: pbm.height, depth: 1, max: 1, tupleType: TupleTypeBlackAndWhite}
+}
+
+// tupleDepth returns the depth required by a standard tuple type, or 0 for other tuple types.
+func tupleDepth(tupleType string) int {
+	switch tupleType {
+	case TupleTypeBlackAndWhite, TupleTypeGrayscale:
+		return 1
+	case TupleTypeBlackAndWhiteAlpha, TupleTypeGrayscaleAlpha:
+		return 2
+	case TupleTypeRGB:
+		return 3
+	case TupleTypeRGBAlpha:
+		return 4
+	}
+	return 0
+}
@@ -0,0 +1,180 @@
+package Netpbm
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// Tests of PAM images: parsing the header, encoding and decoding, and converting to image.Image.
+
+// testPAM returns a PAM image of the given tuple type with a pattern of samples up to max.
+func testPAM(width, height, depth int, max uint16, tupleType string) *PAM {
+	data := make([][]uint16, height)
+	for y := range data {
+		data[y] = make([]uint16, width*depth)
+		for k := range data[y] {
+			data[y][k] = uint16((k*7919 + y*104729) % (int(max) + 1))
+		}
+	}
+	return &PAM{data: data, width: width, height: height, depth: depth, max: max, tupleType: tupleType}
+}
+
+func TestPAMHeader(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		tupleType string
+		depth     int
+		comments  []string
+		err       error
+	}{
+		{
+			name:      "multiple TUPLTYPE lines",
+			data:      "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 2\nMAXVAL 255\nTUPLTYPE COLOR\nTUPLTYPE MASK\nENDHDR\n\x01\x02",
+			tupleType: "COLOR MASK",
+			depth:     2,
+		},
+		{
+			name:      "comments and blank lines",
+			data:      "P7\n# first\nWIDTH 1\n\n  # second\nHEIGHT 1\nDEPTH 1\nMAXVAL 255\nTUPLTYPE GRAYSCALE\nENDHDR\n\x01",
+			tupleType: TupleTypeGrayscale,
+			depth:     1,
+			comments:  []string{"first", "second"},
+		},
+		{
+			name:  "no tuple type",
+			data:  "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 5\nMAXVAL 255\nENDHDR\n\x01\x02\x03\x04\x05",
+			depth: 5,
+		},
+		{
+			name: "depth of another tuple type",
+			data: "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB\nENDHDR\n\x01\x02\x03\x04",
+			err:  ErrBadDimensions,
+		},
+		{
+			name: "missing depth",
+			data: "P7\nWIDTH 1\nHEIGHT 1\nMAXVAL 255\nENDHDR\n",
+			err:  ErrBadDimensions,
+		},
+		{
+			name: "depth above the limit",
+			data: "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 4097\nMAXVAL 255\nENDHDR\n",
+			err:  ErrLimitExceeded,
+		},
+		{
+			name: "missing max value",
+			data: "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 1\nENDHDR\n\x01",
+			err:  ErrBadMaxValue,
+		},
+		{
+			name: "unknown keyword",
+			data: "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 1\nMAXVAL 255\nCOLORS 3\nENDHDR\n\x01",
+			err:  ErrSyntax,
+		},
+		{
+			name: "missing ENDHDR",
+			data: "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 1\nMAXVAL 255\n",
+			err:  ErrTruncated,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pam, err := DecodePAM(bytes.NewReader([]byte(test.data)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if pam.TupleType() != test.tupleType || pam.Depth() != test.depth {
+				t.Errorf("tuple type %q with depth %d, want %q with depth %d", pam.TupleType(), pam.Depth(), test.tupleType, test.depth)
+			}
+			if !reflect.DeepEqual(pam.Comments(), test.comments) {
+				t.Errorf("comments = %q, want %q", pam.Comments(), test.comments)
+			}
+		})
+	}
+}
+
+func TestPAMRoundTrip(t *testing.T) {
+	tests := []struct {
+		depth     int
+		max       uint16
+		tupleType string
+	}{
+		{1, 1, TupleTypeBlackAndWhite},
+		{1, 255, TupleTypeGrayscale},
+		{3, 255, TupleTypeRGB},
+		{4, 65535, TupleTypeRGBAlpha},
+		{5, 1000, ""},
+	}
+	for _, test := range tests {
+		pam := testPAM(7, 3, test.depth, test.max, test.tupleType)
+		pam.SetComments([]string{"pattern"})
+		var buffer bytes.Buffer
+		if err := EncodePAM(&buffer, pam); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodePAM(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, pam) {
+			t.Errorf("%q depth %d max %d: decoded image differs from the encoded one", test.tupleType, test.depth, test.max)
+		}
+	}
+}
+
+func TestPAMAddAlpha(t *testing.T) {
+	pam := testPAM(2, 1, 3, 255, TupleTypeRGB)
+	want := append(pam.At(1, 0), 255)
+	pam.AddAlpha()
+	if pam.TupleType() != TupleTypeRGBAlpha || pam.Depth() != 4 || !pam.HasAlpha() {
+		t.Fatalf("tuple type %q with depth %d after AddAlpha", pam.TupleType(), pam.Depth())
+	}
+	if tuple := pam.At(1, 0); !reflect.DeepEqual(tuple, want) {
+		t.Errorf("tuple = %v, want %v", tuple, want)
+	}
+
+	// Adding an alpha channel twice changes nothing
+	pam.AddAlpha()
+	if pam.Depth() != 4 {
+		t.Errorf("depth = %d after a second AddAlpha, want 4", pam.Depth())
+	}
+}
+
+func TestPAMImage(t *testing.T) {
+	// Images with an alpha channel keep it, with 16-bit samples
+	rgba := testPAM(1, 1, 4, 255, TupleTypeRGBAlpha)
+	rgba.Set(0, 0, []uint16{255, 0, 51, 102})
+	grayAlpha := testPAM(1, 1, 2, 1000, TupleTypeGrayscaleAlpha)
+	grayAlpha.Set(0, 0, []uint16{1000, 0})
+	tests := []struct {
+		name  string
+		pam   *PAM
+		color color.NRGBA64
+	}{
+		{"RGB_ALPHA", rgba, color.NRGBA64{R: 0xffff, G: 0, B: 0x3333, A: 0x6666}},
+		{"GRAYSCALE_ALPHA", grayAlpha, color.NRGBA64{R: 0xffff, G: 0xffff, B: 0xffff, A: 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, ok := test.pam.image().(*image.NRGBA64)
+			if !ok {
+				t.Fatalf("image of type %T, want *image.NRGBA64", test.pam.image())
+			}
+			if c := img.NRGBA64At(0, 0); c != test.color {
+				t.Errorf("color = %v, want %v", c, test.color)
+			}
+		})
+	}
+
+	// Opaque images need no alpha channel
+	if _, ok := testPAM(1, 1, 3, 255, TupleTypeRGB).image().(*image.NRGBA64); ok {
+		t.Error("opaque RGB image converted to *image.NRGBA64")
+	}
+}
@@ -1,90 +1,101 @@
 package Netpbm
 
 import (
-	"bufio"
 	"fmt"
+	"io"
+	"math/bits"
 	"os"
-	"strconv"
 	"strings"
 )
 
 type PBM struct {
-	data          [][]bool
+	pix           []uint8 // rows one after the other, packed 8 pixels per byte as in P4: 1 for black and 0 for white
+	stride        int     // number of bytes between the starts of two consecutive rows
 	width, height int
 	magicNumber   string
+	comments      []string
 }
 
-// ReadPPM reads a PBM image from a file and returns a struct that represents the image.
+// newPBM creates a white PBM image with a pixel buffer of the given size.
+func newPBM(width, height int, magicNumber string) *PBM {
+	// Each row is padded to a byte boundary, like the rows of a P4 raster
+	stride := (width + 7) / 8
+	return &PBM{
+		pix:         make([]uint8, height*stride),
+		stride:      stride,
+		width:       width,
+		height:      height,
+		magicNumber: magicNumber,
+	}
+}
+
+// ReadPBM reads a PBM image from a file and returns a struct that represents the image.
 func ReadPBM(filename string) (*PBM, error) {
 	// Open the file for reading
-	var dimension string
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err // Return an error if file opening fails
 	}
 	defer file.Close()
 
-	// Create a scanner to read from the file
-	scanner := bufio.NewScanner(file)
+	// Decode the image from the file contents
+	return DecodePBM(file)
+}
 
-	// Read the first line to determine the PBM format
-	scanner.Scan()
-	line := scanner.Text()
-	line = strings.TrimSpace(line)
-	if line != "P1" && line != "P4" {
-		return nil, fmt.Errorf("Not a Portable Bitmap file: bad magic number %s", line)
+// DecodePBM reads a PBM image from r and returns a struct that represents the image.
+func DecodePBM(r io.Reader) (*PBM, error) {
+	// Decode without any resource limit
+	return (&DecoderOptions{}).DecodePBM(r)
+}
+
+// DecodePBM reads a PBM image from r within the limits of the options.
+func (o *DecoderOptions) DecodePBM(r io.Reader) (*PBM, error) {
+	// Create a tokenizer so that both header tokens and raw bytes can be read
+	tok := o.newTokenizer(r, "PBM")
+
+	// Read the magic number and the dimensions
+	h, err := tok.readHeader("P1", "P4")
+	if err != nil {
+		return nil, err
 	}
-	magicNumber := line
 
-	// Read and skip comment lines
-	for scanner.Scan() {
-        if scanner.Text()[0] == '#' {
-            continue
-        }
-        break
+	tok.logHeader(h)
 
-    }
+	// Check the dimensions against the limits before allocating the pixels
+	if err := o.checkLimits(tok, h); err != nil {
+		return nil, err
+	}
+	width, height := h.width, h.height
 
-	// Read dimensions (width and height)
-    dimension = scanner.Text()
-    res := strings.Split(dimension, " ")
-    height, _  := strconv.Atoi(res[0])
-    width, _  := strconv.Atoi(res[1])
-	
-	// Read binary data
-	var pbm *PBM
+	// Create a new PBM structure for the read data
+	pbm := newPBM(width, height, h.magicNumber)
+	pbm.comments = tok.comments
 
 	// Check if the PBM image format is "P1"
-	if magicNumber == "P1" {
-		// Read pixel data for P1 format
-		data := make([][]bool, height)
-		for i := range data {
-			data[i] = make([]bool, width)
-		}
-
-		// Iterate over each line of the PBM file to read pixel data
+	if h.magicNumber == "P1" {
+		// Read each pixel, whatever the way the digits are laid out on lines
 		for i := 0; i < height; i++ {
-			scanner.Scan()
-			line := scanner.Text()
-			hori := strings.Fields(line)
-			// Iterate over each field to extract binary pixel values
 			for j := 0; j < width; j++ {
-				verti, _ := strconv.Atoi(hori[j])
-				if verti == 1 {
-					data[i][j] = true
+				value, err := tok.bit()
+				if err != nil {
+					return nil, err
 				}
+				pbm.Set(j, i, value)
 			}
 		}
-		
-		// Create a new PBM structure with the read data
-		pbm = &PBM{
-			data:        data,
-			width:       width,
-			height:      height,
-			magicNumber: magicNumber,
+	} else {
+		if err := tok.endHeader(); err != nil {
+			return nil, err
+		}
+		// The P4 raster has the layout of the pixel buffer, so it is read in place
+		if err := tok.readFull(pbm.pix); err != nil {
+			return nil, err
 		}
-		fmt.Printf("%+v\n", PBM{data, width, height, magicNumber})
+		// Clear the padding bits, which the file may have left set
+		pbm.clearPadding()
 	}
+
+	tok.logDone()
 	return pbm, nil
 }
 
@@ -97,85 +108,165 @@ func (pbm *PBM) Size() (int, int) {
 
 // At retrieves the binary value of a pixel at the specified coordinates in the PBM image.
 func (pbm *PBM) At(x, y int) bool {
-	 // The function retrieves the binary value (true or false) of a pixel at the specified coordinates.
-    // Return the binary value of the pixel at the given coordinates
-	return pbm.data[y][x]
+	// The function retrieves the binary value (true or false) of a pixel at the specified coordinates.
+	// Return the binary value of the pixel at the given coordinates
+	i, mask := pbm.bit(x, y)
+	return pbm.pix[i]&mask != 0
 }
 
 // Set sets the value of a pixel at the specified coordinates in the PBM image.
 func (pbm *PBM) Set(x, y int, value bool) {
 	// The function sets the binary value (true or false) of a pixel at the specified coordinates.
-    // Update the binary value of the pixel at the given coordinates
-	pbm.data[y][x] = value
+	// Update the binary value of the pixel at the given coordinates
+	i, mask := pbm.bit(x, y)
+	if value {
+		pbm.pix[i] |= mask
+	} else {
+		pbm.pix[i] &^= mask
+	}
+}
+
+// bit returns the index in Pix of the byte holding a pixel and the mask of its bit.
+// It panics when the pixel is outside the image, rather than touching the padding bits.
+func (pbm *PBM) bit(x, y int) (int, uint8) {
+	if x < 0 || x >= pbm.width || y < 0 || y >= pbm.height {
+		panic(fmt.Sprintf("Netpbm: PBM pixel (%d, %d) out of range", x, y))
+	}
+	// The most significant bit of each byte holds the leftmost pixel
+	return y*pbm.stride + x/8, 0x80 >> uint(x%8)
+}
+
+// Pix returns the pixel buffer of the PBM image, shared with the image: rows of Stride bytes
+// packed 8 pixels per byte, most significant bit first, 1 for black and 0 for white.
+// The padding bits at the end of each row are kept at 0.
+func (pbm *PBM) Pix() []uint8 {
+	return pbm.pix
+}
+
+// Stride returns the number of bytes between the starts of two consecutive rows of Pix.
+func (pbm *PBM) Stride() int {
+	return pbm.stride
 }
 
-// Save saves the PBM image to a file with the specified filename
+// Save saves the PBM image to a file with the specified filename.
 func (pbm *PBM) Save(filename string) error {
-	// Open the file for writing
-    fileSave, error := os.Create(filename)
-    if error != nil {
-        return error // Return an error if file creation fails
-    }
-	// Ensure the file is closed when the function exits
-	defer fileSave.Close()
-	
+	// Write the image to a temporary file that replaces the destination once complete
+	return saveFile(filename, func(w io.Writer) error {
+		return EncodePBM(w, pbm)
+	})
+}
+
+// EncodePBM writes the PBM image to w.
+func EncodePBM(w io.Writer, pbm *PBM) error {
+	// Encode with the layout of the specification
+	return (&EncoderOptions{}).EncodePBM(w, pbm)
+}
+
+// EncodePBM writes the PBM image to w with the layout of the options.
+func (o *EncoderOptions) EncodePBM(w io.Writer, pbm *PBM) error {
+	return encodeBuffered(w, func(w io.Writer) error {
+		return o.encodePBM(w, pbm)
+	})
+}
+
+// encodePBM writes the PBM image to the buffered writer w.
+func (o *EncoderOptions) encodePBM(w io.Writer, pbm *PBM) error {
+	// Check the magic number before writing anything, as the raster could not follow the header
+	if err := checkMagicNumber("encode", pbm.magicNumber, "P1", "P4"); err != nil {
+		return err
+	}
+
+	plain, err := o.newPlainRow()
+	if err != nil {
+		return err
+	}
+
 	// Write the PBM header to the file
-    fmt.Fprintf(fileSave, "%s\n%d %d\n", pbm.magicNumber, pbm.width, pbm.height)
+	if _, err := fmt.Fprintf(w, "%s\n", pbm.magicNumber); err != nil {
+		return err
+	}
+	if err := writeComments(w, o.comments(pbm.comments)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%d %d\n", pbm.width, pbm.height); err != nil {
+		return err
+	}
 
-	 // Check if the image format is P1
+	// Check if the image format is P1
 	if pbm.magicNumber == "P1" {
 		// Write the pixel data to the file
-		for _, i := range pbm.data {
-			for _, j := range i {
-				if j {
-					fmt.Fprint(fileSave, "1 ")
+		for i := 0; i < pbm.height; i++ {
+			for j := 0; j < pbm.width; j++ {
+				if pbm.At(j, i) {
+					plain.appendUint(1)
 				} else {
-					fmt.Fprint(fileSave, "0 ")
+					plain.appendUint(0)
 				}
 			}
 			// Move to a new line after each row of pixels
-			fmt.Fprintln(fileSave)
+			plain.endRow()
+			if err := plain.flush(w); err != nil {
+				return err
+			}
+		}
+	} else {
+		// The rows of the pixel buffer are already packed as P4 expects them
+		for i := 0; i < pbm.height; i++ {
+			if _, err := w.Write(pbm.pix[i*pbm.stride : (i+1)*pbm.stride]); err != nil {
+				return err
+			}
 		}
 	}
-	// Return nil to indicate successful save
-    return nil
+	return nil
 }
 
 // Invert inverts the values of the pixels in the PBM image.
-func (pbm *PBM) Invert(){
-	// Iterate through each pixel in the PBM image
+func (pbm *PBM) Invert() {
+	// Switch 8 pixels at a time between black (1) and white (0)
+	for i := range pbm.pix {
+		pbm.pix[i] ^= 0xFF
+	}
+	// The padding bits were switched as well
+	pbm.clearPadding()
+}
+
+// clearPadding sets to 0 the bits that pad each row of the PBM image to a byte boundary.
+func (pbm *PBM) clearPadding() {
+	if pbm.width%8 == 0 {
+		return
+	}
+	mask := uint8(0xFF) << uint(8-pbm.width%8)
 	for i := 0; i < pbm.height; i++ {
-		for j := 0; j < pbm.width; j++ {
-			// Check the current value of the pixel
-			if pbm.data[i][j] {
-				// If true, set it to false
-				pbm.data[i][j] = false
-			}else if !pbm.data[i][j] {
-				// If false, set it to true
-				pbm.data[i][j] = true
-			}
-		}
+		pbm.pix[i*pbm.stride+pbm.stride-1] &= mask
 	}
 }
 
 // Flip vertically flips the PBM image.
 func (pbm *PBM) Flip() {
 	// The function performs a vertical flip by swapping the pixel columns from top to bottom.
-    // Iterate through each row of the PBM data and swap corresponding columns from top to bottom
-    for _, height := range pbm.data {
-        for i, j := 0, len(height)-1; i < j; i, j = i+1, j-1 {
-            height[i], height[j] = height[j], height[i]
-        }
-    }
+	// Iterate through each row of the PBM data and swap corresponding columns from top to bottom
+	padding := uint(pbm.stride*8 - pbm.width)
+	for y := 0; y < pbm.height; y++ {
+		row := pbm.pix[y*pbm.stride : (y+1)*pbm.stride]
+		// Reverse the order of the bytes and of the bits within each byte
+		for i, j := 0, len(row)-1; i <= j; i, j = i+1, j-1 {
+			row[i], row[j] = bits.Reverse8(row[j]), bits.Reverse8(row[i])
+		}
+		// The padding bits are now at the start of the row: shift them back to its end
+		if padding > 0 {
+			for k := 0; k < len(row)-1; k++ {
+				row[k] = row[k]<<padding | row[k+1]>>(8-padding)
+			}
+			row[len(row)-1] <<= padding
+		}
+	}
 }
 
 // Flop horizontally flips the PBM image.
-func (pbm *PBM) Flop(){
+func (pbm *PBM) Flop() {
 	// The function performs a horizontal flip by swapping the pixel rows from left to right.
-    // Iterate through the first half of the rows, swapping with their corresponding rows from the end
-    for i, j := 0, len(pbm.data)-1; i < j; i, j = i+1, j-1 {
-        pbm.data[i], pbm.data[j] = pbm.data[j], pbm.data[i]
-    }
+	// Iterate through the first half of the rows, swapping with their corresponding rows from the end
+	swapRows(pbm.pix, pbm.stride, pbm.height, pbm.stride)
 }
 
 // SetMagicNumber sets the magic number of the PBM image.
@@ -183,3 +274,61 @@ func (pbm *PBM) SetMagicNumber(magicNumber string){
 	// This function allows external modification of the magic number of the PBM image.
 	pbm.magicNumber = magicNumber
 }
+
+// Comments returns a copy of the comment lines of the PBM image, without their '#'.
+func (pbm *PBM) Comments() []string {
+	return append([]string(nil), pbm.comments...)
+}
+
+// SetComments replaces the comment lines written after the magic number of the PBM image.
+func (pbm *PBM) SetComments(comments []string) {
+	pbm.comments = append([]string(nil), comments...)
+}
+
+// ToPBM returns a copy of the PBM image, so that PBM images satisfy the Image interface.
+func (pbm *PBM) ToPBM() *PBM {
+	// Copy every row so that the copy does not share pixels with the original
+	c := newPBM(pbm.width, pbm.height, pbm.magicNumber)
+	for i := 0; i < pbm.height; i++ {
+		copy(c.pix[i*c.stride:(i+1)*c.stride], pbm.pix[i*pbm.stride:])
+	}
+	c.comments = append([]string(nil), pbm.comments...)
+	return c
+}
+
+// ToPGM converts the PBM image to a PGM image with a max value of 255.
+// Black pixels become 0 and white pixels become 255.
+func (pbm *PBM) ToPGM() *PGM {
+	// Create a new instance of the PGM structure
+	pgm := newPGM(pbm.width, pbm.height, 255, "P2")
+	for i := 0; i < pbm.height; i++ {
+		for j := 0; j < pbm.width; j++ {
+			// In PBM images true is black
+			if !pbm.At(j, i) {
+				pgm.Set16(j, i, 255)
+			}
+		}
+	}
+	return pgm
+}
+
+// ToPPM converts the PBM image to a PPM image with a max value of 255.
+func (pbm *PBM) ToPPM() *PPM {
+	// Go through the gray image, which already maps black and white to samples
+	return pbm.ToPGM().ToPPM()
+}
+
+// writeComments writes each comment on its own header line, after a '#'.
+// A comment holding line breaks is split over several '#' lines, as a bare
+// line break would end the comment and corrupt the header.
+func writeComments(w io.Writer, comments []string) error {
+	for _, comment := range comments {
+		comment = strings.ReplaceAll(strings.ReplaceAll(comment, "\r\n", "\n"), "\r", "\n")
+		for _, line := range strings.Split(comment, "\n") {
+			if _, err := fmt.Fprintf(w, "# %s\n", line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
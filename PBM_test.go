@@ -0,0 +1,128 @@
+package Netpbm
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Tests of PBM images: encoding and decoding both formats, and transforming packed rows.
+
+// testPBM returns a PBM image with a diagonal pattern of black pixels.
+func testPBM(t *testing.T, width, height int, magicNumber string) *PBM {
+	t.Helper()
+	pbm, err := NewPBM(width, height, WithMagicNumber(magicNumber), WithComments("pattern"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pbm.Set(x, y, (x+2*y)%3 == 0)
+		}
+	}
+	return pbm
+}
+
+func TestPBMRoundTrip(t *testing.T) {
+	tests := []struct {
+		magicNumber   string
+		width, height int
+	}{
+		{"P1", 1, 1},
+		{"P1", 37, 5},
+		{"P4", 8, 3},
+		{"P4", 13, 4},
+		{"P4", 37, 5},
+	}
+	for _, test := range tests {
+		pbm := testPBM(t, test.width, test.height, test.magicNumber)
+		var buffer bytes.Buffer
+		if err := EncodePBM(&buffer, pbm); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodePBM(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, pbm) {
+			t.Errorf("%s %dx%d: decoded image differs from the encoded one", test.magicNumber, test.width, test.height)
+		}
+	}
+}
+
+func TestPBMRawLayout(t *testing.T) {
+	// Rows are packed most significant bit first and padded to a byte boundary
+	data := "P4\n# pattern\n9 2\n\x80\x00\xff\x80"
+	pbm, err := DecodePBM(bytes.NewReader([]byte(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{1, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	if samples := imageSamples(pbm); !reflect.DeepEqual(samples, want) {
+		t.Errorf("pixels = %v, want %v", samples, want)
+	}
+	var buffer bytes.Buffer
+	if err := EncodePBM(&buffer, pbm); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != data {
+		t.Errorf("encoded %q, want %q", buffer.String(), data)
+	}
+}
+
+// checkPBMPadding reports the rows of the PBM image whose padding bits are not 0.
+func checkPBMPadding(t *testing.T, name string, pbm *PBM) {
+	t.Helper()
+	if pbm.width%8 == 0 {
+		return
+	}
+	mask := uint8(0xFF) >> uint(pbm.width%8)
+	for y := 0; y < pbm.height; y++ {
+		if last := pbm.pix[y*pbm.stride+pbm.stride-1]; last&mask != 0 {
+			t.Errorf("%s: padding bits of row %d are %08b, want 0", name, y, last&mask)
+		}
+	}
+}
+
+func TestPBMPackedRows(t *testing.T) {
+	const height = 3
+	// An asymmetric pattern, so that every transformation moves pixels around
+	pixel := func(x, y int) bool {
+		return (x*x+3*y)%5 < 2
+	}
+	for _, width := range []int{1, 7, 8, 9, 13, 17} {
+		tests := []struct {
+			name      string
+			transform func(pbm *PBM)
+			want      func(x, y int) bool
+		}{
+			{"Set", func(pbm *PBM) {}, pixel},
+			{"Flip", (*PBM).Flip, func(x, y int) bool { return pixel(width-1-x, y) }},
+			{"Flop", (*PBM).Flop, func(x, y int) bool { return pixel(x, height-1-y) }},
+			{"Invert", (*PBM).Invert, func(x, y int) bool { return !pixel(x, y) }},
+			{"Flip twice", func(pbm *PBM) { pbm.Flip(); pbm.Flip() }, pixel},
+		}
+		for _, test := range tests {
+			name := fmt.Sprintf("%s width %d", test.name, width)
+			pbm, err := NewPBM(width, height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					pbm.Set(x, y, pixel(x, y))
+				}
+			}
+			test.transform(pbm)
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					if got, want := pbm.At(x, y), test.want(x, y); got != want {
+						t.Errorf("%s: pixel (%d, %d) = %v, want %v", name, x, y, got, want)
+					}
+				}
+			}
+			checkPBMPadding(t, name, pbm)
+		}
+	}
+}
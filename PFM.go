@@ -0,0 +1,314 @@
+package Netpbm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PFM holds a floating-point image. The PFM format has no comments.
+type PFM struct {
+	data          [][]float32
+	width, height int
+	channels      int
+	scale         float32
+	magicNumber   string
+}
+
+// ToneMapper maps a floating-point sample to the [0, 1] range before it is quantized.
+type ToneMapper func(value float32) float32
+
+// ToneMapClamp keeps samples as they are; values outside [0, 1] are clamped afterwards.
+func ToneMapClamp(value float32) float32 {
+	return value
+}
+
+// ToneMapReinhard compresses high dynamic range samples with the Reinhard operator v / (1 + v).
+func ToneMapReinhard(value float32) float32 {
+	// Negative samples have no meaning for the operator and are clamped afterwards
+	if value <= 0 {
+		return 0
+	}
+	return value / (1 + value)
+}
+
+// ReadPFM reads a PFM image from a file and returns a struct that represents the image.
+func ReadPFM(filename string) (*PFM, error) {
+	// Open the file for reading
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err // Return an error if file opening fails
+	}
+	defer file.Close()
+
+	// Decode the image from the file contents
+	return DecodePFM(file)
+}
+
+// DecodePFM reads a PFM image from r and returns a struct that represents the image.
+func DecodePFM(r io.Reader) (*PFM, error) {
+	// Decode without any resource limit
+	return (&DecoderOptions{}).DecodePFM(r)
+}
+
+// DecodePFM reads a PFM image from r within the limits of the options.
+func (o *DecoderOptions) DecodePFM(r io.Reader) (*PFM, error) {
+	// Create a tokenizer so that both header lines and raw bytes can be read
+	tok := o.newTokenizer(r, "PFM")
+
+	// Read the magic number, the dimensions and the scale
+	h, err := readPFMHeader(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	tok.logHeader(h)
+
+	// Check the dimensions against the limits before allocating the pixels
+	if err := o.checkLimits(tok, h); err != nil {
+		return nil, err
+	}
+	width, height, channels, scale := h.width, h.height, h.depth, h.scale
+	var order binary.ByteOrder = binary.BigEndian
+	if scale < 0 {
+		order = binary.LittleEndian
+	}
+
+	// Read the raw samples; rows are stored from the bottom of the image to the top
+	data := make([][]float32, height)
+	row := make([]byte, 4*width*channels)
+	for i := height - 1; i >= 0; i-- {
+		if err := tok.readFull(row); err != nil {
+			return nil, err
+		}
+		data[i] = make([]float32, width*channels)
+		for j := range data[i] {
+			data[i][j] = math.Float32frombits(order.Uint32(row[4*j:]))
+		}
+	}
+
+	tok.logDone()
+
+	// Create a new instance of the PFM structure
+	return &PFM{
+		data:        data,
+		width:       width,
+		height:      height,
+		channels:    channels,
+		scale:       float32(scale),
+		magicNumber: h.magicNumber,
+	}, nil
+}
+
+// readPFMHeader reads the magic number, the dimensions and the scale of a PFM image.
+func readPFMHeader(tok *tokenizer) (header, error) {
+	// Read the first line to determine the number of channels
+	line, err := tok.readLine("magic number")
+	if err != nil {
+		return header{}, err
+	}
+	magicNumber := strings.TrimSpace(line)
+	var channels int
+	switch magicNumber {
+	case "PF":
+		channels = 3
+	case "Pf":
+		channels = 1
+	default:
+		return header{}, tok.fail("magic number", magicNumber, ErrBadMagic)
+	}
+
+	// Read dimensions (width and height)
+	line, err = tok.readLine("dimensions")
+	if err != nil {
+		return header{}, err
+	}
+	dimension := strings.Fields(line)
+	if len(dimension) != 2 {
+		return header{}, tok.fail("dimensions", line, ErrBadDimensions)
+	}
+	width, err := strconv.Atoi(dimension[0])
+	if err != nil || width < 1 {
+		return header{}, tok.fail("width", dimension[0], ErrBadDimensions)
+	}
+	height, err := strconv.Atoi(dimension[1])
+	if err != nil || height < 1 {
+		return header{}, tok.fail("height", dimension[1], ErrBadDimensions)
+	}
+
+	// Read the scale, whose sign gives the byte order of the samples
+	line, err = tok.readLine("scale")
+	if err != nil {
+		return header{}, err
+	}
+	scale, err := strconv.ParseFloat(strings.TrimSpace(line), 32)
+	if err != nil || scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+		return header{}, tok.fail("scale", line, ErrBadMaxValue)
+	}
+
+	return header{magicNumber: magicNumber, width: width, height: height, depth: channels, scale: scale}, nil
+}
+
+// Size returns the width and height of the PFM image.
+func (pfm *PFM) Size() (int, int) {
+	return pfm.width, pfm.height
+}
+
+// Channels returns the number of samples per pixel: 3 for PF images and 1 for Pf images.
+func (pfm *PFM) Channels() int {
+	return pfm.channels
+}
+
+// At retrieves a copy of the samples of a pixel at the specified coordinates in the PFM image.
+func (pfm *PFM) At(x, y int) []float32 {
+	// Copy the samples so that the caller cannot modify the image by accident
+	samples := make([]float32, pfm.channels)
+	copy(samples, pfm.data[y][x*pfm.channels:(x+1)*pfm.channels])
+	return samples
+}
+
+// Set sets the samples of a pixel at the specified coordinates in the PFM image.
+func (pfm *PFM) Set(x, y int, samples []float32) {
+	// Only the first channels samples are used
+	copy(pfm.data[y][x*pfm.channels:(x+1)*pfm.channels], samples)
+}
+
+// Save saves the PFM image to a file with the specified filename.
+func (pfm *PFM) Save(filename string) error {
+	// Write the image to a temporary file that replaces the destination once complete
+	return saveFile(filename, func(w io.Writer) error {
+		return EncodePFM(w, pfm)
+	})
+}
+
+// EncodePFM writes the PFM image to w.
+func EncodePFM(w io.Writer, pfm *PFM) error {
+	return encodeBuffered(w, func(w io.Writer) error {
+		return encodePFM(w, pfm)
+	})
+}
+
+// encodePFM writes the PFM image to the buffered writer w.
+func encodePFM(w io.Writer, pfm *PFM) error {
+	// Write the PFM header; a negative scale announces little-endian samples
+	_, err := fmt.Fprintf(w, "%s\n%d %d\n%s\n", pfm.magicNumber, pfm.width, pfm.height, strconv.FormatFloat(float64(pfm.scale), 'f', -1, 32))
+	if err != nil {
+		return err
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if pfm.scale < 0 {
+		order = binary.LittleEndian
+	}
+
+	// Write the rows from the bottom of the image to the top
+	row := make([]byte, 4*pfm.width*pfm.channels)
+	for i := pfm.height - 1; i >= 0; i-- {
+		for j, sample := range pfm.data[i] {
+			order.PutUint32(row[4*j:], math.Float32bits(sample))
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quantize tone maps a sample, clamps it to [0, 1] and scales it to the max value.
+func quantize(value float32, toneMap ToneMapper, max uint16) uint16 {
+	value = toneMap(value)
+	// NaN and negative samples become 0, samples above 1 become max
+	if !(value > 0) {
+		return 0
+	}
+	if value >= 1 {
+		return max
+	}
+	return uint16(value*float32(max) + 0.5)
+}
+
+// ToPPM converts the PFM image to a P6 PPM image with the given max value.
+// A nil toneMap behaves like ToneMapClamp.
+func (pfm *PFM) ToPPM(max uint16, toneMap ToneMapper) *PPM {
+	if toneMap == nil {
+		toneMap = ToneMapClamp
+	}
+
+	// Create a new instance of the PPM structure
+	ppm := newPPM(pfm.width, pfm.height, max, "P6")
+	for i := 0; i < pfm.height; i++ {
+		for j := 0; j < pfm.width; j++ {
+			samples := pfm.data[i][j*pfm.channels : (j+1)*pfm.channels]
+			// Gray images replicate their single sample on the three channels
+			if pfm.channels == 1 {
+				gray := quantize(samples[0], toneMap, max)
+				ppm.Set16(j, i, Pixel16{gray, gray, gray})
+			} else {
+				ppm.Set16(j, i, Pixel16{
+					R: quantize(samples[0], toneMap, max),
+					G: quantize(samples[1], toneMap, max),
+					B: quantize(samples[2], toneMap, max),
+				})
+			}
+		}
+	}
+	return ppm
+}
+
+// ToPGM converts the PFM image to a P5 PGM image with the given max value.
+// Color images are averaged into gray levels; a nil toneMap behaves like ToneMapClamp.
+func (pfm *PFM) ToPGM(max uint16, toneMap ToneMapper) *PGM {
+	if toneMap == nil {
+		toneMap = ToneMapClamp
+	}
+
+	// Create a new instance of the PGM structure
+	pgm := newPGM(pfm.width, pfm.height, max, "P5")
+	for i := 0; i < pfm.height; i++ {
+		for j := 0; j < pfm.width; j++ {
+			samples := pfm.data[i][j*pfm.channels : (j+1)*pfm.channels]
+			// Average the channels before tone mapping, as PPM.ToPGM does
+			var sum float32
+			for _, sample := range samples {
+				sum += sample
+			}
+			pgm.Set16(j, i, quantize(sum/float32(pfm.channels), toneMap, max))
+		}
+	}
+	return pgm
+}
+
+// ToPFM converts the PPM image to a color PFM image with samples in [0, 1].
+func (ppm *PPM) ToPFM() *PFM {
+	// Normalize every sample by the max value
+	data := make([][]float32, ppm.height)
+	for i := range data {
+		data[i] = make([]float32, 0, 3*ppm.width)
+		for j := 0; j < ppm.width; j++ {
+			pixel := ppm.At16(j, i)
+			data[i] = append(data[i],
+				float32(pixel.R)/float32(ppm.max),
+				float32(pixel.G)/float32(ppm.max),
+				float32(pixel.B)/float32(ppm.max))
+		}
+	}
+	// A scale of -1 means little-endian samples with no extra scaling
+	return &PFM{data: data, width: ppm.width, height: ppm.height, channels: 3, scale: -1, magicNumber: "PF"}
+}
+
+// ToPFM converts the PGM image to a gray PFM image with samples in [0, 1].
+func (pgm *PGM) ToPFM() *PFM {
+	// Normalize every sample by the max value
+	data := make([][]float32, pgm.height)
+	for i := range data {
+		data[i] = make([]float32, pgm.width)
+		for j := range data[i] {
+			data[i][j] = float32(pgm.At16(j, i)) / float32(pgm.max)
+		}
+	}
+	// A scale of -1 means little-endian samples with no extra scaling
+	return &PFM{data: data, width: pgm.width, height: pgm.height, channels: 1, scale: -1, magicNumber: "Pf"}
+}
@@ -0,0 +1,117 @@
+package Netpbm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Tests of PFM images: encoding and decoding both byte orders.
+
+// testPFM returns a PFM image with a pattern of samples, some of them negative or above 1.
+func testPFM(t *testing.T, width, height, channels int) *PFM {
+	t.Helper()
+	pfm, err := NewPFM(width, height, channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make([]float32, channels)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for k := range samples {
+				samples[k] = float32(x-2*y+k) / 3
+			}
+			pfm.Set(x, y, samples)
+		}
+	}
+	return pfm
+}
+
+func TestNewPFM(t *testing.T) {
+	tests := []struct {
+		channels    int
+		magicNumber string
+	}{
+		{1, "Pf"},
+		{3, "PF"},
+	}
+	for _, test := range tests {
+		pfm, err := NewPFM(4, 2, test.channels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pfm.magicNumber != test.magicNumber || pfm.Channels() != test.channels {
+			t.Errorf("%d channels: magic number %q with %d channels, want %q", test.channels, pfm.magicNumber, pfm.Channels(), test.magicNumber)
+		}
+		if samples := pfm.At(3, 1); !reflect.DeepEqual(samples, make([]float32, test.channels)) {
+			t.Errorf("%d channels: new pixel = %v, want black", test.channels, samples)
+		}
+	}
+	if _, err := NewPFM(4, 2, 2); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("2 channels: error = %v, want ErrBadMagic", err)
+	}
+	if _, err := NewPFM(-1, 2, 3); !errors.Is(err, ErrBadDimensions) {
+		t.Errorf("negative width: error = %v, want ErrBadDimensions", err)
+	}
+}
+
+func TestPFMRoundTrip(t *testing.T) {
+	for _, channels := range []int{1, 3} {
+		pfm := testPFM(t, 5, 3, channels)
+		var buffer bytes.Buffer
+		if err := EncodePFM(&buffer, pfm); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodePFM(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, pfm) {
+			t.Errorf("%d channels: decoded image differs from the encoded one", channels)
+		}
+	}
+}
+
+func TestPFMByteOrder(t *testing.T) {
+	// The sign of the scale gives the byte order; rows go from the bottom to the top
+	samples := func(order binary.AppendByteOrder) string {
+		row := order.AppendUint32(nil, math.Float32bits(1))
+		return string(order.AppendUint32(row, math.Float32bits(-2.5)))
+	}
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"big-endian", "Pf\n1 2\n1\n" + samples(binary.BigEndian)},
+		{"little-endian", "Pf\n1 2\n-1\n" + samples(binary.LittleEndian)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pfm, err := DecodePFM(bytes.NewReader([]byte(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if top, bottom := pfm.At(0, 0), pfm.At(0, 1); top[0] != -2.5 || bottom[0] != 1 {
+				t.Errorf("samples = %v %v, want [-2.5] [1]", top, bottom)
+			}
+
+			// Encoding keeps the byte order of the decoded image
+			var buffer bytes.Buffer
+			if err := EncodePFM(&buffer, pfm); err != nil {
+				t.Fatal(err)
+			}
+			if buffer.String() != test.data {
+				t.Errorf("encoded %q, want %q", buffer.String(), test.data)
+			}
+		})
+	}
+}
+
+func TestPFMTruncated(t *testing.T) {
+	if _, err := DecodePFM(bytes.NewReader([]byte("PF\n1 1\n-1\n\x00\x00\x80\x3f"))); !errors.Is(err, ErrTruncated) {
+		t.Errorf("error = %v, want ErrTruncated", err)
+	}
+}
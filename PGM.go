@@ -1,92 +1,107 @@
 package Netpbm
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
-	"strings"
 )
 
 type PGM struct {
-	data        [][]uint8
+	pix           []uint8 // rows one after the other, with one byte per sample or two big-endian bytes when max is above 255
+	stride        int     // number of bytes between the starts of two consecutive rows
 	width, height int
-	magicNumber  string
-	max          uint8
+	magicNumber   string
+	max           uint16
+	comments      []string
 }
 
-// ReadPPM reads a PGM image from a file and returns a struct that represents the image.
+// newPGM creates a black PGM image with a pixel buffer of the given size.
+func newPGM(width, height int, max uint16, magicNumber string) *PGM {
+	stride := width * sampleSize(max)
+	return &PGM{
+		pix:         make([]uint8, height*stride),
+		stride:      stride,
+		width:       width,
+		height:      height,
+		magicNumber: magicNumber,
+		max:         max,
+	}
+}
+
+// ReadPGM reads a PGM image from a file and returns a struct that represents the image.
 func ReadPGM(filename string) (*PGM, error) {
-    // Open the file for reading
-    file, err := os.Open(filename)
-    if err != nil {
-        return nil, err // Return an error if file opening fails
-    }
-    defer file.Close()
-
-    // Create a scanner to read from the file
-    scanner := bufio.NewScanner(file)
-
-    // Read the first line to determine the PGM format
-    scanner.Scan()
-    line := scanner.Text()
-    line = strings.TrimSpace(line)
-    if line != "P2" && line != "P5" {
-        return nil, fmt.Errorf("Not a Portable Bitmap file: bad magic number %s", line)
-    }
-    magicNumber := line
-
-    // Read and skip comment lines
-    for scanner.Scan() {
-        if strings.HasPrefix(scanner.Text(), "#") {
-            continue
-        }
-        break
-    }
-
-    // Read dimensions (width and height)
-    dimension := strings.Fields(scanner.Text())
-    width, _ := strconv.Atoi(dimension[0])
-    height, _ := strconv.Atoi(dimension[1])
-
-    // Read the max value (not declared in your initial code)
-    scanner.Scan()
-    maxValue, _ := strconv.Atoi(scanner.Text())
-
-    // Read binary data
-    var pgm *PGM
-
-    // Check if the PGM image format is "P2"
-    if magicNumber == "P2" {
-        // Read pixel data for P2 format
-        data := make([][]uint8, height)
-        for i := range data {
-            data[i] = make([]uint8, width)
-        }
-
-        // Iterate over each row in the PGM image
-        for i := 0; i < height; i++ {
-            scanner.Scan()
-            line := scanner.Text()
-            hori := strings.Fields(line)
-            // Iterate over each pixel value in the row
-            for j := 0; j < width; j++ {
-                pixel, _ := strconv.Atoi(hori[j])
-                data[i][j] = uint8(pixel)
-            }
-        }
-
-        // Create a new instance of the PGM structure
-        pgm = &PGM{
-            data:        data,
-            width:       width,
-            height:      height,
-            magicNumber: magicNumber,
-            max:         uint8(maxValue),
-        }
-        fmt.Printf("%+v\n", PGM{data, width, height, magicNumber, uint8(maxValue)})
-    }
-    return pgm, nil
+	// Open the file for reading
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err // Return an error if file opening fails
+	}
+	defer file.Close()
+
+	// Decode the image from the file contents
+	return DecodePGM(file)
+}
+
+// DecodePGM reads a PGM image from r and returns a struct that represents the image.
+func DecodePGM(r io.Reader) (*PGM, error) {
+	// Decode without any resource limit
+	return (&DecoderOptions{}).DecodePGM(r)
+}
+
+// DecodePGM reads a PGM image from r within the limits of the options.
+func (o *DecoderOptions) DecodePGM(r io.Reader) (*PGM, error) {
+	// Create a tokenizer so that both header tokens and raw bytes can be read
+	tok := o.newTokenizer(r, "PGM")
+
+	// Read the magic number, the dimensions and the max value
+	h, err := tok.readHeader("P2", "P5")
+	if err != nil {
+		return nil, err
+	}
+
+	tok.logHeader(h)
+
+	// Check the dimensions against the limits before allocating the pixels
+	if err := o.checkLimits(tok, h); err != nil {
+		return nil, err
+	}
+	width, height, maxValue := h.width, h.height, uint16(h.max)
+
+	// Create a new instance of the PGM structure
+	pgm := newPGM(width, height, maxValue, h.magicNumber)
+	pgm.comments = tok.comments
+
+	// Check if the PGM image format is "P2"
+	if h.magicNumber == "P2" {
+		// Read each sample, whatever the way the values are laid out on lines
+		for i := 0; i < height; i++ {
+			for j := 0; j < width; j++ {
+				value, err := tok.sample(h.max)
+				if err != nil {
+					return nil, err
+				}
+				pgm.Set16(j, i, value)
+			}
+		}
+	} else {
+		if err := tok.endHeader(); err != nil {
+			return nil, err
+		}
+		// The raw raster has the layout of the pixel buffer, so it is read straight into it
+		if err := tok.readFull(pgm.pix); err != nil {
+			return nil, err
+		}
+		// Samples can only exceed max values other than 255 and 65535
+		if maxValue != 255 && maxValue != 65535 {
+			for k := 0; k < width*height; k++ {
+				if _, err := tok.rawSample(pgm.pix, k, maxValue); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	tok.logDone()
+	return pgm, nil
 }
 
 // Size returns the width and height of the PGM image
@@ -97,82 +112,152 @@ func (pgm *PGM) Size() (int, int) {
 }
 
 // At retrieves the intensity value of a pixel at the specified coordinates in the PGM image.
-func (pgm *PGM) At(x, y int) uint8{
-    // The function retrieves the intensity value of a pixel at the specified coordinates.
-    // Return the intensity value of the pixel at the given coordinates
-	return pgm.data[y][x]
+// When the max value is above 255 the intensity is scaled down to 8 bits; use At16 to get the full sample.
+func (pgm *PGM) At(x, y int) uint8 {
+	// The function retrieves the intensity value of a pixel at the specified coordinates.
+	// Return the intensity value of the pixel at the given coordinates
+	return to8(pgm.At16(x, y), pgm.max)
 }
 
 // Set sets the value of a pixel at the specified coordinates in the PGM image.
-func (pgm *PGM) Set(x, y int, value uint8){
-    // The function sets the binary value (true or false) of a pixel at the specified coordinates.
-    // Update the value of the pixel at the given coordinates
-	pgm.data[y][x] = value
+// When the max value is above 255 the value is scaled up from 8 bits; use Set16 to store a full sample.
+func (pgm *PGM) Set(x, y int, value uint8) {
+	// The function sets the binary value (true or false) of a pixel at the specified coordinates.
+	// Update the value of the pixel at the given coordinates
+	pgm.Set16(x, y, from8(value, pgm.max))
+}
+
+// At16 retrieves the full sample value of a pixel at the specified coordinates in the PGM image.
+func (pgm *PGM) At16(x, y int) uint16 {
+	// Return the raw sample, which may exceed 255 for 16-bit images
+	return readSample(pgm.row(y), x, pgm.max)
+}
+
+// Set16 sets the full sample value of a pixel at the specified coordinates in the PGM image.
+func (pgm *PGM) Set16(x, y int, value uint16) {
+	// Update the raw sample of the pixel at the given coordinates
+	putSample(pgm.row(y), x, value, pgm.max)
+}
+
+// Pix returns the pixel buffer of the PGM image, shared with the image: rows of Stride bytes
+// with one byte per sample, or two big-endian bytes when the max value is above 255,
+// which is the layout of a P5 raster, of image.Gray and of image.Gray16.
+func (pgm *PGM) Pix() []uint8 {
+	return pgm.pix
 }
 
-// Save saves the PGM image to a file with the specified filename
+// Stride returns the number of bytes between the starts of two consecutive rows of Pix.
+func (pgm *PGM) Stride() int {
+	return pgm.stride
+}
+
+// row returns the bytes of the i-th row of the PGM image, so that indexing past its width panics.
+func (pgm *PGM) row(i int) []uint8 {
+	return pgm.pix[i*pgm.stride : i*pgm.stride+pgm.width*sampleSize(pgm.max)]
+}
+
+// Save saves the PGM image to a file with the specified filename.
 func (pgm *PGM) Save(filename string) error {
-    // Open the file for writing
-    fileSave, error := os.Create(filename)
-    if error != nil {
-        return error // Return an error if file creation fails
-    }
-    // Ensure the file is closed when the function exits
-    defer fileSave.Close()
-
-    // Write the PGM header to the file
-    fmt.Fprintf(fileSave, "%s\n%d %d\n%d\n", pgm.magicNumber, pgm.width, pgm.height, pgm.max)
-
-     // Check if the image format is P2
-    if pgm.magicNumber == "P2" {
-        // Write the pixel data to the file
-        for i := 0; i < pgm.height; i++ {
-            for j := 0; j < pgm.width; j++ {
-                fmt.Fprintf(fileSave, "%v ", pgm.data[i][j])
-            }
-            fmt.Fprintf(fileSave, "\n")
-        }
-        // Move to a new line after each row of pixels
-        fmt.Fprintln(fileSave)
-    }
-    // Return nil to indicate successful save
-    return nil
+	// Write the image to a temporary file that replaces the destination once complete
+	return saveFile(filename, func(w io.Writer) error {
+		return EncodePGM(w, pgm)
+	})
+}
+
+// EncodePGM writes the PGM image to w.
+func EncodePGM(w io.Writer, pgm *PGM) error {
+	// Encode with the layout of the specification
+	return (&EncoderOptions{}).EncodePGM(w, pgm)
+}
+
+// EncodePGM writes the PGM image to w with the layout of the options.
+func (o *EncoderOptions) EncodePGM(w io.Writer, pgm *PGM) error {
+	return encodeBuffered(w, func(w io.Writer) error {
+		return o.encodePGM(w, pgm)
+	})
+}
+
+// encodePGM writes the PGM image to the buffered writer w.
+func (o *EncoderOptions) encodePGM(w io.Writer, pgm *PGM) error {
+	// Check the magic number before writing anything, as the raster could not follow the header
+	if err := checkMagicNumber("encode", pgm.magicNumber, "P2", "P5"); err != nil {
+		return err
+	}
+
+	plain, err := o.newPlainRow()
+	if err != nil {
+		return err
+	}
+
+	// Write the PGM header to the file
+	if _, err := fmt.Fprintf(w, "%s\n", pgm.magicNumber); err != nil {
+		return err
+	}
+	if err := writeComments(w, o.comments(pgm.comments)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%d %d\n%d\n", pgm.width, pgm.height, pgm.max); err != nil {
+		return err
+	}
+
+	// Check if the image format is P2
+	if pgm.magicNumber == "P2" {
+		// Write the pixel data to the file
+		for i := 0; i < pgm.height; i++ {
+			for j := 0; j < pgm.width; j++ {
+				plain.appendUint(uint64(pgm.At16(j, i)))
+			}
+			// Move to a new line after each row of pixels
+			plain.endRow()
+			if err := plain.flush(w); err != nil {
+				return err
+			}
+		}
+	} else {
+		// The pixel buffer already holds the raw rows, one byte per sample or two big-endian bytes when max is above 255
+		for i := 0; i < pgm.height; i++ {
+			if _, err := w.Write(pgm.row(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // Invert inverts the intensity values of the pixels in the PGM image.
 func (pgm *PGM) Invert() {
-    // Check if the PGM image dimensions are valid
-    if pgm.width == 0 || pgm.height == 0 {
-        return // Return if the image dimensions are invalid
-    }
+	// Check if the PGM image dimensions are valid
+	if pgm.width == 0 || pgm.height == 0 {
+		return // Return if the image dimensions are invalid
+	}
 
-    // Iterate through each pixel in the PGM image
-    for i := 0; i < pgm.height; i++ {
-        for j := 0; j < pgm.width; j++ {
-            // Invert the intensity value by subtracting it from the maximum intensity
-            pgm.data[i][j] = uint8(pgm.max) - pgm.data[i][j]
-        }
-    }
+	// Iterate through each pixel in the PGM image
+	for i := 0; i < pgm.height; i++ {
+		for j := 0; j < pgm.width; j++ {
+			// Invert the intensity value by subtracting it from the maximum intensity
+			pgm.Set16(j, i, pgm.max-pgm.At16(j, i))
+		}
+	}
 }
 
 // Flip vertically flips the PGM image.
 func (pgm *PGM) Flip() {
-    // The function performs a vertical flip by swapping the pixel columns from top to bottom.
-    // Iterate through each row of the PGM data and swap corresponding columns from top to bottom
-    for _, height := range pgm.data {
-        for i, j := 0, len(height)-1; i < j; i, j = i+1, j-1 {
-            height[i], height[j] = height[j], height[i]
-        }
-    }
+	// The function performs a vertical flip by swapping the pixel columns from top to bottom.
+	// Iterate through each row of the PGM data and swap corresponding columns from top to bottom
+	for y := 0; y < pgm.height; y++ {
+		for i, j := 0, pgm.width-1; i < j; i, j = i+1, j-1 {
+			left, right := pgm.At16(i, y), pgm.At16(j, y)
+			pgm.Set16(i, y, right)
+			pgm.Set16(j, y, left)
+		}
+	}
 }
 
 // Flop horizontally flips the PGM image.
-func (pgm *PGM) Flop(){
-    // The function performs a horizontal flip by swapping the pixel rows from left to right.
-    // Iterate through the first half of the rows, swapping with their corresponding rows from the end
-    for i, j := 0, len(pgm.data)-1; i < j; i, j = i+1, j-1 {
-        pgm.data[i], pgm.data[j] = pgm.data[j], pgm.data[i]
-    }
+func (pgm *PGM) Flop() {
+	// The function performs a horizontal flip by swapping the pixel rows from left to right.
+	// Iterate through the first half of the rows, swapping with their corresponding rows from the end
+	swapRows(pgm.pix, pgm.stride, pgm.height, pgm.width*sampleSize(pgm.max))
 }
 
 // SetMagicNumber sets the magic number of the PGM image.
@@ -181,71 +266,164 @@ func (pgm *PGM) SetMagicNumber(magicNumber string){
 	pgm.magicNumber = magicNumber
 }
 
+// Comments returns a copy of the comment lines of the PGM image, without their '#'.
+func (pgm *PGM) Comments() []string {
+	return append([]string(nil), pgm.comments...)
+}
+
+// SetComments replaces the comment lines written after the magic number of the PGM image.
+func (pgm *PGM) SetComments(comments []string) {
+	pgm.comments = append([]string(nil), comments...)
+}
+
 // SetMaxValue sets the max value of the PGM image.
 func (pgm *PGM) SetMaxValue(maxValue uint8) {
-    // Set the multiplicator
-    multiplicator := float64(maxValue) / float64(pgm.max)
-    // ppm.max becomes our new max valuea
-    pgm.max = uint8(maxValue)
+	// Delegate to the 16-bit version, which covers every valid max value
+	pgm.SetMaxValue16(uint16(maxValue))
+}
 
-    // Updates pixel values with the new max value
-    for i := range pgm.data {
-        for j := range pgm.data[i] {
-            //Modifies the value of each pixel proportionally
-            pgm.data[i][j] = uint8(float64(pgm.data[i][j]) * float64(multiplicator))
-        }
-    }
+// SetMaxValue16 sets the max value of the PGM image, allowing values above 255.
+func (pgm *PGM) SetMaxValue16(maxValue uint16) {
+	// Set the multiplicator
+	multiplicator := float64(maxValue) / float64(pgm.max)
+	// Keep the old image to read the samples from, since their size may change
+	old := *pgm
+	// ppm.max becomes our new max valuea
+	pgm.max = maxValue
+	if sampleSize(maxValue) != sampleSize(old.max) {
+		resized := newPGM(pgm.width, pgm.height, maxValue, pgm.magicNumber)
+		pgm.pix, pgm.stride = resized.pix, resized.stride
+	}
+
+	// Updates pixel values with the new max value
+	for i := 0; i < pgm.height; i++ {
+		for j := 0; j < pgm.width; j++ {
+			//Modifies the value of each pixel proportionally
+			pgm.Set16(j, i, uint16(float64(old.At16(j, i))*float64(multiplicator)))
+		}
+	}
 
 }
 
 // Rotate90CW rotates the PGM image 90 degrees clockwise.
-func (pgm *PGM) Rotate90CW(){
-    // Create a new matrix to store rotated data
-    rotate := make([][]uint8, pgm.width)
-	for i := range rotate {
-		rotate[i] = make([]uint8, pgm.height)
-	}
+func (pgm *PGM) Rotate90CW() {
+	// Create a new buffer to store rotated data
+	rotate := newPGM(pgm.height, pgm.width, pgm.max, pgm.magicNumber)
 
-    // Iterate through each pixel of the original PGM image
+	// Iterate through each pixel of the original PGM image
 	for i := 0; i < pgm.height; i++ {
 		for j := 0; j < pgm.width; j++ {
-            // Rotate each pixel 90 degrees clockwise and assign it to the new matrix
-			rotate[j][pgm.height-1-i] = pgm.data[i][j]
+			// Rotate each pixel 90 degrees clockwise and assign it to the new buffer
+			rotate.Set16(pgm.height-1-i, j, pgm.At16(j, i))
 		}
 	}
 
-    // Update the PGM image data with the rotated matrix
-	pgm.data = rotate
-    // Swap height and width values to reflect the rotation
+	// Update the PGM image data with the rotated buffer
+	pgm.pix, pgm.stride = rotate.pix, rotate.stride
+	// Swap height and width values to reflect the rotation
 	pgm.height, pgm.width = pgm.width, pgm.height
 }
 
-// ToPBM converts a PGM image to a PBM image by thresholding based on intensity.
+// ToPGM returns a copy of the PGM image, so that PGM images satisfy the Image interface.
+func (pgm *PGM) ToPGM() *PGM {
+	// Copy every row so that the copy does not share pixels with the original
+	c := newPGM(pgm.width, pgm.height, pgm.max, pgm.magicNumber)
+	for i := 0; i < pgm.height; i++ {
+		copy(c.row(i), pgm.row(i))
+	}
+	c.comments = append([]string(nil), pgm.comments...)
+	return c
+}
+
+// ToPPM converts the PGM image to a PPM image by copying each gray level to the three channels.
+func (pgm *PGM) ToPPM() *PPM {
+	// Create a new instance of the PPM structure
+	ppm := newPPM(pgm.width, pgm.height, pgm.max, "P3")
+	for i := 0; i < pgm.height; i++ {
+		for j := 0; j < pgm.width; j++ {
+			gray := pgm.At16(j, i)
+			ppm.Set16(j, i, Pixel16{gray, gray, gray})
+		}
+	}
+	return ppm
+}
+
+// ToPBM converts a PGM image to a PBM image by thresholding based on intensity:
+// pixels whose gray level is at most half the max value become black.
 func (pgm *PGM) ToPBM() *PBM {
-    // Create a new matrix for PBM data
-    var data [][]bool
-    data = make([][]bool, pgm.height)
-    for i := 0; i < pgm.width; i++ {
-        data[i] = make([]bool, pgm.width)
-    }
-
-    // Convert PGM pixels to PBM binary values based on intensity threshold
-    for i := 0; i < pgm.height; i++ {
-        for j := 0; j < pgm.width; j++ {
-            // Thresholding: If intensity is greater than half of max, set to true; otherwise, set to false
-            if pgm.data[i][j] > pgm.max/2 {
-                data[i][j] = true
-            } else {
-                data[i][j] = false
-            }
-        }
-    }
-
-    // Create a new instance of the PBM structure
-    return &PBM{
-        data:        data,
-        width:       pgm.width,
-        height:      pgm.height,
-        magicNumber: "P1",
-    }
+	// Create a new instance of the PBM structure
+	pbm := newPBM(pgm.width, pgm.height, "P1")
+
+	// Convert PGM pixels to PBM binary values based on intensity threshold
+	for i := 0; i < pgm.height; i++ {
+		for j := 0; j < pgm.width; j++ {
+			// A pixel is black (true) when its gray level is in the lower half of the range
+			pbm.Set(j, i, pgm.At16(j, i) <= pgm.max/2)
+		}
+	}
+
+	return pbm
+}
+
+
+// sampleSize returns the number of bytes used by one raw sample for the given max value.
+func sampleSize(max uint16) int {
+	// The Netpbm formats use two bytes per sample as soon as max exceeds 255
+	if max > 255 {
+		return 2
+	}
+	return 1
+}
+
+// readSample decodes the j-th raw sample of a row, which is big-endian when it takes two bytes.
+func readSample(row []byte, j int, max uint16) uint16 {
+	if max > 255 {
+		return uint16(row[2*j])<<8 | uint16(row[2*j+1])
+	}
+	return uint16(row[j])
+}
+
+// putSample encodes the j-th raw sample of a row, most significant byte first when it takes two bytes.
+func putSample(row []byte, j int, value uint16, max uint16) {
+	if max > 255 {
+		row[2*j], row[2*j+1] = byte(value>>8), byte(value)
+	} else {
+		row[j] = byte(value)
+	}
+}
+
+// swapRows reverses the order of the height rows of length bytes of a pixel buffer.
+func swapRows(pix []uint8, stride, height, length int) {
+	row := make([]uint8, length)
+	for i, j := 0, height-1; i < j; i, j = i+1, j-1 {
+		top, bottom := pix[i*stride:i*stride+length], pix[j*stride:j*stride+length]
+		copy(row, top)
+		copy(top, bottom)
+		copy(bottom, row)
+	}
+}
+
+// appendSample appends a raw sample to a row, most significant byte first when it takes two bytes.
+func appendSample(row []byte, value uint16, max uint16) []byte {
+	if max > 255 {
+		return append(row, byte(value>>8), byte(value))
+	}
+	return append(row, byte(value))
+}
+
+// to8 scales a sample down to 8 bits when the max value does not fit in a byte.
+func to8(value uint16, max uint16) uint8 {
+	// Round to the nearest value so that to8 and from8 are inverse of each other
+	if max > 255 {
+		return uint8((uint32(value)*255 + uint32(max)/2) / uint32(max))
+	}
+	return uint8(value)
+}
+
+// from8 scales an 8-bit value up to the sample range when the max value does not fit in a byte.
+func from8(value uint8, max uint16) uint16 {
+	if max > 255 {
+		return uint16((uint32(value)*uint32(max) + 127) / 255)
+	}
+	return uint16(value)
 }
@@ -0,0 +1,81 @@
+package Netpbm
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Tests of PGM images: encoding and decoding both formats with 8- and 16-bit samples.
+
+// testPGM returns a PGM image with a pattern of samples up to max.
+func testPGM(t *testing.T, width, height int, max uint16, magicNumber string) *PGM {
+	t.Helper()
+	pgm, err := NewPGM(width, height, max, WithMagicNumber(magicNumber), WithComments("pattern"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pgm.Set16(x, y, uint16((x*7919+y*104729)%(int(max)+1)))
+		}
+	}
+	return pgm
+}
+
+func TestPGMRoundTrip(t *testing.T) {
+	tests := []struct {
+		magicNumber   string
+		width, height int
+		max           uint16
+	}{
+		{"P2", 40, 3, 255},
+		{"P2", 40, 3, 65535},
+		{"P2", 5, 5, 3},
+		{"P5", 9, 7, 255},
+		{"P5", 9, 7, 65535},
+		{"P5", 9, 7, 1000},
+		{"P5", 1, 1, 1},
+	}
+	for _, test := range tests {
+		pgm := testPGM(t, test.width, test.height, test.max, test.magicNumber)
+		var buffer bytes.Buffer
+		if err := EncodePGM(&buffer, pgm); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodePGM(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, pgm) {
+			t.Errorf("%s %dx%d max %d: decoded image differs from the encoded one", test.magicNumber, test.width, test.height, test.max)
+		}
+	}
+}
+
+func TestPGMRawSamples(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		samples []uint16
+		err     error
+	}{
+		{"8-bit", "P5 3 1 255\n\x00\x7f\xff", []uint16{0, 127, 255}, nil},
+		{"16-bit big-endian", "P5 2 1 65535\n\x01\x02\xff\xfe", []uint16{0x0102, 0xfffe}, nil},
+		{"16-bit max above 255", "P5 2 1 256\n\x01\x00\x00\x01", []uint16{256, 1}, nil},
+		{"8-bit sample above max", "P5 2 1 100\n\x64\x65", nil, ErrSampleOutOfRange},
+		{"16-bit sample above max", "P5 1 1 1000\n\x03\xe9", nil, ErrSampleOutOfRange},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pgm, err := DecodePGM(bytes.NewReader([]byte(test.data)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+			if err == nil && !reflect.DeepEqual(imageSamples(pgm), test.samples) {
+				t.Errorf("samples = %v, want %v", imageSamples(pgm), test.samples)
+			}
+		})
+	}
+}
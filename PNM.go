@@ -0,0 +1,69 @@
+package Netpbm
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// Image is the behaviour shared by PBM, PGM and PPM images, which lets callers
+// handle a .pnm file without knowing its format in advance.
+type Image interface {
+	// Size returns the width and height of the image.
+	Size() (int, int)
+	// Invert inverts the pixels of the image.
+	Invert()
+	// Flip mirrors the image left to right.
+	Flip()
+	// Flop mirrors the image top to bottom.
+	Flop()
+	// Save saves the image to a file.
+	Save(filename string) error
+	// SetMagicNumber switches between the plain and raw variants of the format.
+	SetMagicNumber(magicNumber string)
+	// ToPBM, ToPGM and ToPPM convert the image; converting to its own format returns a copy.
+	ToPBM() *PBM
+	ToPGM() *PGM
+	ToPPM() *PPM
+}
+
+// Read reads a PBM, PGM or PPM image from a file, detecting the format from its magic number.
+func Read(filename string) (Image, error) {
+	// Open the file for reading
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err // Return an error if file opening fails
+	}
+	defer file.Close()
+
+	// Decode the image from the file contents
+	return Decode(file)
+}
+
+// Decode reads a PBM, PGM or PPM image from r, detecting the format from its magic number.
+// The concrete type of the result is *PBM, *PGM or *PPM.
+func Decode(r io.Reader) (Image, error) {
+	// Decode without any resource limit
+	return (&DecoderOptions{}).Decode(r)
+}
+
+// Decode reads a PBM, PGM or PPM image from r within the limits of the options.
+func (o *DecoderOptions) Decode(r io.Reader) (Image, error) {
+	// Peek at the magic number without consuming it
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(2)
+	if err != nil {
+		return nil, newTokenizer(reader, "Netpbm").readError("magic number", err)
+	}
+
+	// Hand the whole image to the decoder of the detected format
+	switch string(magic) {
+	case "P1", "P4":
+		return o.DecodePBM(reader)
+	case "P2", "P5":
+		return o.DecodePGM(reader)
+	case "P3", "P6":
+		return o.DecodePPM(reader)
+	}
+	return nil, &ParseError{Format: "Netpbm", Line: 1, Field: "magic number", Value: string(magic), Err: ErrBadMagic}
+}
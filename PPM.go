@@ -1,111 +1,122 @@
 package Netpbm
 
 import (
-	"bufio"
 	"fmt"
+	"io"
+	"math"
 	"os"
-	"strconv"
-	"strings"
-    "math"
 )
 
 type PPM struct {
-	data        [][]Pixel
+	pix           []uint8 // rows one after the other, with three samples per pixel of one byte, or two big-endian bytes when max is above 255
+	stride        int     // number of bytes between the starts of two consecutive rows
 	width, height int
-	magicNumber  string
-	max          uint8
+	magicNumber   string
+	max           uint16
+	comments      []string
+}
+
+// newPPM creates a black PPM image with a pixel buffer of the given size.
+func newPPM(width, height int, max uint16, magicNumber string) *PPM {
+	stride := 3 * width * sampleSize(max)
+	return &PPM{
+		pix:         make([]uint8, height*stride),
+		stride:      stride,
+		width:       width,
+		height:      height,
+		magicNumber: magicNumber,
+		max:         max,
+	}
 }
 
 type Pixel struct {
 	R, G, B uint8
 }
 
+// Pixel16 holds the full samples of a pixel, which may exceed 255 when the max value does.
+type Pixel16 struct {
+	R, G, B uint16
+}
+
 type Point struct{
     X, Y int
 }
 
 // ReadPPM reads a PPM image from a file and returns a struct that represents the image.
 func ReadPPM(filename string) (*PPM, error) {
-    // Open the file for reading
+	// Open the file for reading
 	file, err := os.Open(filename)
-    if err != nil {
-        return nil, err // Return an error if file opening fails
-    }
-    defer file.Close()
+	if err != nil {
+		return nil, err // Return an error if file opening fails
+	}
+	defer file.Close()
 
-    // Create a scanner to read from the file
-    scanner := bufio.NewScanner(file)
+	// Decode the image from the file contents
+	return DecodePPM(file)
+}
 
-    // Read the first line to determine the PPM format
-    scanner.Scan()
-    line := scanner.Text()
-    line = strings.TrimSpace(line)
-    if line != "P3" && line != "P6" {
-        return nil, fmt.Errorf("Not a Portable Bitmap file: bad magic number %s", line)
-    }
-    magicNumber := line
+// DecodePPM reads a PPM image from r and returns a struct that represents the image.
+func DecodePPM(r io.Reader) (*PPM, error) {
+	// Decode without any resource limit
+	return (&DecoderOptions{}).DecodePPM(r)
+}
 
-	// Read and skip comment lines
-	for scanner.Scan() {
-		if strings.HasPrefix(scanner.Text(), "#") {
-			continue
-		}
-		break
+// DecodePPM reads a PPM image from r within the limits of the options.
+func (o *DecoderOptions) DecodePPM(r io.Reader) (*PPM, error) {
+	// Create a tokenizer so that both header tokens and raw bytes can be read
+	tok := o.newTokenizer(r, "PPM")
+
+	// Read the magic number, the dimensions and the max value
+	h, err := tok.readHeader("P3", "P6")
+	if err != nil {
+		return nil, err
 	}
 
-    // Read dimensions (width and height)
-	dimension := strings.Fields(scanner.Text())
-	width, _ := strconv.Atoi(dimension[0])
-	height, _ := strconv.Atoi(dimension[1])
+	tok.logHeader(h)
 
-    // Read the maximum pixel value
-	scanner.Scan()
-    maxValue, _ := strconv.Atoi(scanner.Text())
+	// Check the dimensions against the limits before allocating the pixels
+	if err := o.checkLimits(tok, h); err != nil {
+		return nil, err
+	}
+	width, height, maxValue := h.width, h.height, uint16(h.max)
 
-	// Read pixel data
-	var ppm *PPM
+	// Create a new instance of the PPM structure
+	ppm := newPPM(width, height, maxValue, h.magicNumber)
+	ppm.comments = tok.comments
 
-    // Check if the PPM image format is "P3"
-	if magicNumber == "P3" {
-        // Read pixel data for P3 format
-		data := make([][]Pixel, height)
-		for i := range data {
-			data[i] = make([]Pixel, width)
-		}
-
-        // Iterate over each row in the PPM image
+	// Check if the PPM image format is "P3"
+	if h.magicNumber == "P3" {
+		// Read the RGB values of each pixel, whatever the way they are laid out on lines
+		var rgb [3]uint16
 		for i := 0; i < height; i++ {
-			scanner.Scan()
-			line := scanner.Text()
-			values := strings.Fields(line)
-            // Iterate over each pixel value in the row
 			for j := 0; j < width; j++ {
-				if j == 0 {
-                    // Read RGB values for the first pixel in the line
-					r, _ := strconv.Atoi(values[0])
-					g, _ := strconv.Atoi(values[1])
-					b, _ := strconv.Atoi(values[2])
-					data[i][j] = Pixel{uint8(r), uint8(g), uint8(b)}
-				} else {
-                    // Read RGB values for subsequent pixels in the line
-					r, _ := strconv.Atoi(values[3*j])
-					g, _ := strconv.Atoi(values[3*j+1])
-					b, _ := strconv.Atoi(values[3*j+2])
-					data[i][j] = Pixel{uint8(r), uint8(g), uint8(b)}
+				for k := range rgb {
+					if rgb[k], err = tok.sample(h.max); err != nil {
+						return nil, err
+					}
 				}
+				ppm.Set16(j, i, Pixel16{rgb[0], rgb[1], rgb[2]})
 			}
 		}
-
-        // Create a new instance of the PPM structure
-		ppm = &PPM{
-			data:        data,
-			width:       width,
-			height:      height,
-			magicNumber: magicNumber,
-			max:         uint8(maxValue),
+	} else {
+		if err := tok.endHeader(); err != nil {
+			return nil, err
+		}
+		// The raw raster has the layout of the pixel buffer, so it is read straight into it
+		if err := tok.readFull(ppm.pix); err != nil {
+			return nil, err
+		}
+		// Samples can only exceed max values other than 255 and 65535
+		if maxValue != 255 && maxValue != 65535 {
+			for k := 0; k < 3*width*height; k++ {
+				if _, err := tok.rawSample(ppm.pix, k, maxValue); err != nil {
+					return nil, err
+				}
+			}
 		}
-		fmt.Printf("%+v\n", PPM{data, width, height, magicNumber, uint8(maxValue)})
 	}
+
+	tok.logDone()
 	return ppm, nil
 }
 
@@ -117,93 +128,164 @@ func (ppm *PPM) Size() (int, int) {
 }
 
 // At retrieves the RGB values of a pixel at the specified coordinates in the PPM image.
-func (ppm *PPM) At(x, y int) Pixel{
-    // The function retrieves the RGB values of a pixel at the specified coordinates.
-    // Return the RGB values of the pixel at the given coordinates
-	return ppm.data[y][x]
+// When the max value is above 255 the samples are scaled down to 8 bits; use At16 to get the full samples.
+func (ppm *PPM) At(x, y int) Pixel {
+	// The function retrieves the RGB values of a pixel at the specified coordinates.
+	// Return the RGB values of the pixel at the given coordinates
+	pixel := ppm.At16(x, y)
+	return Pixel{to8(pixel.R, ppm.max), to8(pixel.G, ppm.max), to8(pixel.B, ppm.max)}
 }
 
 // Set sets the value of a pixel at the specified coordinates in the PPM image.
-func (ppm *PPM) Set(x, y int, value Pixel){
-    // The function sets the RGB values of a pixel at the specified coordinates.
-    // Update the RGB values of the pixel at the given coordinates
-	ppm.data[y][x] = value
+// When the max value is above 255 the samples are scaled up from 8 bits; use Set16 to store full samples.
+func (ppm *PPM) Set(x, y int, value Pixel) {
+	// The function sets the RGB values of a pixel at the specified coordinates.
+	// Update the RGB values of the pixel at the given coordinates
+	ppm.Set16(x, y, Pixel16{from8(value.R, ppm.max), from8(value.G, ppm.max), from8(value.B, ppm.max)})
+}
+
+// At16 retrieves the full RGB samples of a pixel at the specified coordinates in the PPM image.
+func (ppm *PPM) At16(x, y int) Pixel16 {
+	// Return the raw samples, which may exceed 255 for 16-bit images
+	row := ppm.row(y)
+	return Pixel16{readSample(row, 3*x, ppm.max), readSample(row, 3*x+1, ppm.max), readSample(row, 3*x+2, ppm.max)}
+}
+
+// Set16 sets the full RGB samples of a pixel at the specified coordinates in the PPM image.
+func (ppm *PPM) Set16(x, y int, value Pixel16) {
+	// Update the raw samples of the pixel at the given coordinates
+	row := ppm.row(y)
+	putSample(row, 3*x, value.R, ppm.max)
+	putSample(row, 3*x+1, value.G, ppm.max)
+	putSample(row, 3*x+2, value.B, ppm.max)
+}
+
+// Pix returns the pixel buffer of the PPM image, shared with the image: rows of Stride bytes
+// with three samples per pixel of one byte, or two big-endian bytes when the max value is above 255,
+// which is the layout of a P6 raster.
+func (ppm *PPM) Pix() []uint8 {
+	return ppm.pix
+}
+
+// Stride returns the number of bytes between the starts of two consecutive rows of Pix.
+func (ppm *PPM) Stride() int {
+	return ppm.stride
+}
+
+// row returns the bytes of the i-th row of the PPM image, so that indexing past its width panics.
+func (ppm *PPM) row(i int) []uint8 {
+	return ppm.pix[i*ppm.stride : i*ppm.stride+3*ppm.width*sampleSize(ppm.max)]
 }
 
 // Save saves the PPM image to a file with the specified filename.
 func (ppm *PPM) Save(filename string) error {
-    // Open the file for writing
-    file, err := os.Create(filename)
-    if err != nil {
-        return err // Return an error if file creation fails
-    }
-    // Ensure the file is closed when the function exits
-    defer file.Close()
+	// Write the image to a temporary file that replaces the destination once complete
+	return saveFile(filename, func(w io.Writer) error {
+		return EncodePPM(w, ppm)
+	})
+}
 
-    // Write the PPM header to the file
-    _, err = fmt.Fprintf(file, "%s\n%d %d\n%d\n", ppm.magicNumber, ppm.width, ppm.height, ppm.max)
-    if err != nil {
-        return err
-    }
+// EncodePPM writes the PPM image to w.
+func EncodePPM(w io.Writer, ppm *PPM) error {
+	// Encode with the layout of the specification
+	return (&EncoderOptions{}).EncodePPM(w, ppm)
+}
 
-     // Check if the image format is P3
-    if ppm.magicNumber == "P3" {
-        // Write the pixel data to the file
-        for i := 0; i < ppm.height; i++ {
-            for j := 0; j < ppm.width; j++ {
-                pixel := ppm.data[i][j]
-                _, err := fmt.Fprintf(file, "%d %d %d ", pixel.R, pixel.G, pixel.B)
-                if err != nil {
-                    return err
-                }
-            }
-            // Move to a new line after each row of pixels
-            _, err := fmt.Fprintln(file)
-            if err != nil {
-                return err
-            }
-        }
-    }
-    // Return nil to indicate successful save
-    return nil
+// EncodePPM writes the PPM image to w with the layout of the options.
+func (o *EncoderOptions) EncodePPM(w io.Writer, ppm *PPM) error {
+	return encodeBuffered(w, func(w io.Writer) error {
+		return o.encodePPM(w, ppm)
+	})
+}
+
+// encodePPM writes the PPM image to the buffered writer w.
+func (o *EncoderOptions) encodePPM(w io.Writer, ppm *PPM) error {
+	// Check the magic number before writing anything, as the raster could not follow the header
+	if err := checkMagicNumber("encode", ppm.magicNumber, "P3", "P6"); err != nil {
+		return err
+	}
+
+	plain, err := o.newPlainRow()
+	if err != nil {
+		return err
+	}
+
+	// Write the PPM header
+	_, err = fmt.Fprintf(w, "%s\n", ppm.magicNumber)
+	if err != nil {
+		return err
+	}
+	if err := writeComments(w, o.comments(ppm.comments)); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%d %d\n%d\n", ppm.width, ppm.height, ppm.max)
+	if err != nil {
+		return err
+	}
+
+	// Check if the image format is P3
+	if ppm.magicNumber == "P3" {
+		// Write the pixel data
+		for i := 0; i < ppm.height; i++ {
+			for j := 0; j < ppm.width; j++ {
+				pixel := ppm.At16(j, i)
+				plain.appendUint(uint64(pixel.R))
+				plain.appendUint(uint64(pixel.G))
+				plain.appendUint(uint64(pixel.B))
+			}
+			// Move to a new line after each row of pixels
+			plain.endRow()
+			if err := plain.flush(w); err != nil {
+				return err
+			}
+		}
+	} else {
+		// The pixel buffer already holds the raw rows, one byte per sample or two big-endian bytes when max is above 255
+		for i := 0; i < ppm.height; i++ {
+			if _, err := w.Write(ppm.row(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // Invert inverts the colors of the PPM image.
 func (ppm *PPM) Invert() {
-    // Iterate through each pixel in the PPM image
-    for i := 0; i < ppm.height; i++ {
-        for j := 0; j < ppm.width; j++ {
-            // Get the original pixel value
-            pixel := ppm.data[i][j]
-            invertedPixel := Pixel{
-                // Invert each color component of the pixel by subtracting it from the maximum value
-                R: ppm.max - pixel.R,
-                G: ppm.max - pixel.G,
-                B: ppm.max - pixel.B,
-            }
-            // Update the pixel with the inverted values
-            ppm.data[i][j] = invertedPixel
-        }
-    }
+	// Iterate through each pixel in the PPM image
+	for i := 0; i < ppm.height; i++ {
+		for j := 0; j < ppm.width; j++ {
+			// Get the original pixel value
+			pixel := ppm.At16(j, i)
+			invertedPixel := Pixel16{
+				// Invert each color component of the pixel by subtracting it from the maximum value
+				R: ppm.max - pixel.R,
+				G: ppm.max - pixel.G,
+				B: ppm.max - pixel.B,
+			}
+			// Update the pixel with the inverted values
+			ppm.Set16(j, i, invertedPixel)
+		}
+	}
 }
 
 // Flip vertically flips the PPM image.
 func (ppm *PPM) Flip() {
-    // The function performs a vertical flip by swapping the pixel columns from top to bottom.
-    // Iterate through each row of the PPM data and swap corresponding columns from top to bottom
-    for _, height := range ppm.data {
-        for i, j := 0, len(height)-1; i < j; i, j = i+1, j-1 {
-            height[i], height[j] = height[j], height[i]
-        }
-    }
+	// The function performs a vertical flip by swapping the pixel columns from top to bottom.
+	// Iterate through each row of the PPM data and swap corresponding columns from top to bottom
+	for y := 0; y < ppm.height; y++ {
+		for i, j := 0, ppm.width-1; i < j; i, j = i+1, j-1 {
+			left, right := ppm.At16(i, y), ppm.At16(j, y)
+			ppm.Set16(i, y, right)
+			ppm.Set16(j, y, left)
+		}
+	}
 }
 // Flop horizontally flips the PPM image.
-func (ppm *PPM) Flop(){
-    // The function performs a horizontal flip by swapping the pixel rows from left to right.
-    // Iterate through the first half of the rows, swapping with their corresponding rows from the end
-    for i, j := 0, len(ppm.data)-1; i < j; i, j = i+1, j-1 {
-        ppm.data[i], ppm.data[j] = ppm.data[j], ppm.data[i]
-    }
+func (ppm *PPM) Flop() {
+	// The function performs a horizontal flip by swapping the pixel rows from left to right.
+	// Iterate through the first half of the rows, swapping with their corresponding rows from the end
+	swapRows(ppm.pix, ppm.stride, ppm.height, 3*ppm.width*sampleSize(ppm.max))
 }
 
 // SetMagicNumber sets the magic number of the PPM image.
@@ -212,241 +294,234 @@ func (ppm *PPM) SetMagicNumber(magicNumber string) {
     ppm.magicNumber = magicNumber
 }
 
+// Comments returns a copy of the comment lines of the PPM image, without their '#'.
+func (ppm *PPM) Comments() []string {
+	return append([]string(nil), ppm.comments...)
+}
+
+// SetComments replaces the comment lines written after the magic number of the PPM image.
+func (ppm *PPM) SetComments(comments []string) {
+	ppm.comments = append([]string(nil), comments...)
+}
+
 // SetMaxValue sets the max value of the PPM image.
 func (ppm *PPM) SetMaxValue(maxValue uint8) {
-    // Check if the new maximum value is different from the current value
-    if maxValue == ppm.max {
-        return // No need to make modifications if the maximum value is the same
-    }
+	// Delegate to the 16-bit version, which covers every valid max value
+	ppm.SetMaxValue16(uint16(maxValue))
+}
 
-    // Calculate the proportion factor to adjust pixel values
-    scaleFactor := float64(maxValue) / float64(ppm.max)
-
-    // Adjust pixel data based on the new maximum value
-    for i := 0; i < ppm.height; i++ {
-        for j := 0; j < ppm.width; j++ {
-            // Get the original pixel value
-            pixel := ppm.data[i][j]
-
-            // Adjust each color component of the pixel using the scaleFactor
-            adjustedPixel := Pixel{
-                R: uint8(float64(pixel.R) * scaleFactor),
-                G: uint8(float64(pixel.G) * scaleFactor),
-                B: uint8(float64(pixel.B) * scaleFactor),
-            }
-            // Update the pixel with the adjusted values
-            ppm.data[i][j] = adjustedPixel
-        }
-    }
+// SetMaxValue16 sets the max value of the PPM image, allowing values above 255.
+func (ppm *PPM) SetMaxValue16(maxValue uint16) {
+	// Check if the new maximum value is different from the current value
+	if maxValue == ppm.max {
+		return // No need to make modifications if the maximum value is the same
+	}
+
+	// Calculate the proportion factor to adjust pixel values
+	scaleFactor := float64(maxValue) / float64(ppm.max)
 
-    // Update the new maximum value
-    ppm.max = maxValue
+	// Keep the old image to read the samples from, since their size may change
+	old := *ppm
+	ppm.max = maxValue
+	if sampleSize(maxValue) != sampleSize(old.max) {
+		resized := newPPM(ppm.width, ppm.height, maxValue, ppm.magicNumber)
+		ppm.pix, ppm.stride = resized.pix, resized.stride
+	}
+
+	// Adjust pixel data based on the new maximum value
+	for i := 0; i < ppm.height; i++ {
+		for j := 0; j < ppm.width; j++ {
+			// Get the original pixel value
+			pixel := old.At16(j, i)
+
+			// Adjust each color component of the pixel using the scaleFactor
+			adjustedPixel := Pixel16{
+				R: uint16(float64(pixel.R) * scaleFactor),
+				G: uint16(float64(pixel.G) * scaleFactor),
+				B: uint16(float64(pixel.B) * scaleFactor),
+			}
+			// Update the pixel with the adjusted values
+			ppm.Set16(j, i, adjustedPixel)
+		}
+	}
 }
 
 // Rotate90CW rotates the PPM image 90 degrees clockwise.
-func (ppm *PPM) Rotate90CW(){
-    // Create a new matrix to store rotated data
-    rotate := make([][]Pixel, ppm.width)
-	for i := range rotate {
-		rotate[i] = make([]Pixel, ppm.height)
-	}
+func (ppm *PPM) Rotate90CW() {
+	// Create a new buffer to store rotated data
+	rotate := newPPM(ppm.height, ppm.width, ppm.max, ppm.magicNumber)
 
-    // Iterate through each pixel of the original PPM image
+	// Iterate through each pixel of the original PPM image
 	for i := 0; i < ppm.height; i++ {
 		for j := 0; j < ppm.width; j++ {
-            // Rotate each pixel 90 degrees clockwise and assign it to the new matrix
-			rotate[j][ppm.height-1-i] = ppm.data[i][j]
+			// Rotate each pixel 90 degrees clockwise and assign it to the new buffer
+			rotate.Set16(ppm.height-1-i, j, ppm.At16(j, i))
 		}
 	}
 
-    // Update the PPM image data with the rotated matrix
-	ppm.data = rotate
-    // Swap height and width values to reflect the rotation
+	// Update the PPM image data with the rotated buffer
+	ppm.pix, ppm.stride = rotate.pix, rotate.stride
+	// Swap height and width values to reflect the rotation
 	ppm.height, ppm.width = ppm.width, ppm.height
 }
 
+// ToPPM returns a copy of the PPM image, so that PPM images satisfy the Image interface.
+func (ppm *PPM) ToPPM() *PPM {
+	// Copy every row so that the copy does not share pixels with the original
+	c := newPPM(ppm.width, ppm.height, ppm.max, ppm.magicNumber)
+	for i := 0; i < ppm.height; i++ {
+		copy(c.row(i), ppm.row(i))
+	}
+	c.comments = append([]string(nil), ppm.comments...)
+	return c
+}
+
 // ToPGM converts the PPM image to a PGM image
 func (ppm *PPM) ToPGM() *PGM {
-    // Create a new matrix for PGM data
-    pgmData := make([][]uint8, ppm.height)
-    for i := range pgmData {
-        pgmData[i] = make([]uint8, ppm.width)
-    }
+	// Create a new instance of the PGM structure
+	pgm := newPGM(ppm.width, ppm.height, ppm.max, "P2")
 
-    // Convert PPM pixels to PGM grayscale
-    for i := 0; i < ppm.height; i++ {
-        for j := 0; j < ppm.width; j++ {
-            // Extract the RGB values of the pixel
-            pixel := ppm.data[i][j]
-            // Calculate the average grayscale value
-            averageValue := uint8((uint32(pixel.R) + uint32(pixel.G) + uint32(pixel.B)) / 3)
-            // Assign the average value to the corresponding pixel in the PGM data matrix
-            pgmData[i][j] = averageValue
-        }
-    }
-
-    // Create a new instance of the PGM structure
-    pgm := &PGM{
-        data:        pgmData,
-        width:       ppm.width,
-        height:      ppm.height,
-        magicNumber: "P2",
-        max:         ppm.max,
-    }
+	// Convert PPM pixels to PGM grayscale
+	for i := 0; i < ppm.height; i++ {
+		for j := 0; j < ppm.width; j++ {
+			// Extract the RGB values of the pixel
+			pixel := ppm.At16(j, i)
+			// Calculate the average grayscale value
+			averageValue := uint16((uint32(pixel.R) + uint32(pixel.G) + uint32(pixel.B)) / 3)
+			// Assign the average value to the corresponding pixel in the PGM image
+			pgm.Set16(j, i, averageValue)
+		}
+	}
 
-    return pgm
+	return pgm
 }
 
-// ToPBM converts the PPM image to a PBM image.
+// ToPBM converts the PPM image to a PBM image, with the threshold of PGM.ToPBM.
 func (ppm *PPM) ToPBM() *PBM {
-    // Convert PPM image to PGM
-    pgm := ppm.ToPGM()
-
-    // Convert PGM image to PBM
-    var data [][]bool
-    data = make([][]bool, pgm.height)
-    for i := range data {
-        data[i] = make([]bool, pgm.width)
-    }
-
-    // Iterate through each pixel in the PGM image
-    for i := 0; i < pgm.height; i++ {
-        for j := 0; j < pgm.width; j++ {
-             // Check if the intensity of the pixel is greater than half of the maximum intensity
-            // If true, set the corresponding pixel in the PBM image to true (1), else set it to false (0)
-            if pgm.data[i][j] > pgm.max/2 {
-                data[i][j] = true
-            } else {
-                data[i][j] = false
-            }
-        }
-    }
-
-    // Create a new instance of the PBM structure
-    pbm := &PBM{
-        data:        data,
-        width:       pgm.width,
-        height:      pgm.height,
-        magicNumber: "P1",
-    }
-
-    return pbm
+	// Go through the gray image, so that both conversions use the same threshold
+	return ppm.ToPGM().ToPBM()
 }
 
 // DrawLine draws a line between two points on the PPM image.
 func (ppm *PPM) DrawLine(p1, p2 Point, color Pixel) {
-    // Handle points outside the image bounds
-    if p1.X < 0 || p1.X >= ppm.width || p1.Y < 0 || p1.Y >= ppm.height {
-        // Find the intersection point with the image bounds
-        if p1.X < 0 {
-            p1.X = 0
-        } else if p1.X >= ppm.width {
-            p1.X = ppm.width - 1
-        }
-
-        if p1.Y < 0 {
-            p1.Y = 0
-        } else if p1.Y >= ppm.height {
-            p1.Y = ppm.height - 1
-        }
-    }
+	// Handle points outside the image bounds
+	if p1.X < 0 || p1.X >= ppm.width || p1.Y < 0 || p1.Y >= ppm.height {
+		// Find the intersection point with the image bounds
+		if p1.X < 0 {
+			p1.X = 0
+		} else if p1.X >= ppm.width {
+			p1.X = ppm.width - 1
+		}
 
-    dx := p2.X - p1.X
-    dy := p2.Y - p1.Y
+		if p1.Y < 0 {
+			p1.Y = 0
+		} else if p1.Y >= ppm.height {
+			p1.Y = ppm.height - 1
+		}
+	}
 
-    // Determine the direction of the line
-    var sx, sy int
-    if dx > 0 {
-        sx = 1
-    } else {
-        sx = -1
-        dx = -dx
-    }
-    if dy > 0 {
-        sy = 1
-    }else {
-        sy = -1
-        dy = -dy
-    }
+	dx := p2.X - p1.X
+	dy := p2.Y - p1.Y
 
-    err := dx - dy
-
-    // Draw the line
-    for {
-        // Check if the current point is within the image bounds
-        if p1.X >= 0 && p1.X < ppm.width && p1.Y >= 0 && p1.Y < ppm.height {
-            ppm.data[p1.Y][p1.X] = color
-        }
-
-        // Break the loop when the end point is reached
-        if p1.X == p2.X && p1.Y == p2.Y {
-            break
-        }
-
-        e2 := 2 * err
-        if e2 > -dy {
-            err -= dy
-            p1.X += sx
-        }
-        if e2 < dx {
-            err += dx
-            p1.Y += sy
-        }
-    }
+	// Determine the direction of the line
+	var sx, sy int
+	if dx > 0 {
+		sx = 1
+	} else {
+		sx = -1
+		dx = -dx
+	}
+	if dy > 0 {
+		sy = 1
+	} else {
+		sy = -1
+		dy = -dy
+	}
+
+	err := dx - dy
+
+	// Draw the line
+	for {
+		// Check if the current point is within the image bounds
+		if p1.X >= 0 && p1.X < ppm.width && p1.Y >= 0 && p1.Y < ppm.height {
+			ppm.Set(p1.X, p1.Y, color)
+		}
+
+		// Break the loop when the end point is reached
+		if p1.X == p2.X && p1.Y == p2.Y {
+			break
+		}
+
+		e2 := 2 * err
+		if e2 > -dy {
+			err -= dy
+			p1.X += sx
+		}
+		if e2 < dx {
+			err += dx
+			p1.Y += sy
+		}
+	}
 }
 
 // DrawCircle draws a tcircle
 func (ppm *PPM) DrawCircle(center Point, radius int, color Pixel) {
 
-    // Iterate through each pixel in the image.
-    for x := 0; x < ppm.height; x++ {
-        for y := 0; y < ppm.width; y++ {
-            // Calculate the distance from the current pixel to the center of the circle.
-            dx := float64(x) - float64(center.X)
-            dy := float64(y) - float64(center.Y)
-            distance := math.Sqrt(dx*dx + dy*dy)
-
-            // Check that the distance to the center is approximately equal to the specified radius. 
-            //The condition "math.Abs(distance-float64(radius)) < 1.0" allows a small margin of error, checking that the distance is less than the specified radius.
-            if math.Abs(distance-float64(radius)) < 1.0 && distance < float64(radius) {
-                // If the conditions are met, set the color of the pixel to the specified color.
-                ppm.Set(x, y, color)
-            }
-        }
-    }
-    // Draw four points around the circle to complete its outline.
-    ppm.Set(center.X-(radius-1), center.Y, color)
-    ppm.Set(center.X+(radius-1), center.Y, color)
-    ppm.Set(center.X, center.Y+(radius-1), color)
-    ppm.Set(center.X, center.Y-(radius-1), color)
+	// Iterate through each pixel in the image.
+	for x := 0; x < ppm.width; x++ {
+		for y := 0; y < ppm.height; y++ {
+			// Calculate the distance from the current pixel to the center of the circle.
+			dx := float64(x) - float64(center.X)
+			dy := float64(y) - float64(center.Y)
+			distance := math.Sqrt(dx*dx + dy*dy)
+
+			// Check that the distance to the center is approximately equal to the specified radius.
+			//The condition "math.Abs(distance-float64(radius)) < 1.0" allows a small margin of error, checking that the distance is less than the specified radius.
+			if math.Abs(distance-float64(radius)) < 1.0 && distance < float64(radius) {
+				// If the conditions are met, set the color of the pixel to the specified color.
+				ppm.Set(x, y, color)
+			}
+		}
+	}
+	// Draw four points around the circle to complete its outline, skipping those outside the image.
+	for _, p := range []Point{
+		{center.X - (radius - 1), center.Y},
+		{center.X + (radius - 1), center.Y},
+		{center.X, center.Y + (radius - 1)},
+		{center.X, center.Y - (radius - 1)},
+	} {
+		if p.X >= 0 && p.X < ppm.width && p.Y >= 0 && p.Y < ppm.height {
+			ppm.Set(p.X, p.Y, color)
+		}
+	}
 }
 
 // DrawFilledCircle draws a circle with the specified dimensions and color on the PPM image.
 func (ppm *PPM) DrawFilledCircle(center Point, radius int, color Pixel) {
-    //draw the outline of the circle.
-    ppm.DrawCircle(center, radius, color)
-
-    // Iterate through each row of the image.
-    for i := 0; i < ppm.height; i++ {
-        var positions []int
-        var number_points int
-        // Iterate through each column of the image.
-        for j := 0; j < ppm.width; j++ {
-            // Check if the pixel at (i, j) has the specified color.
-            if ppm.data[i][j] == color {
-                number_points += 1
-                positions = append(positions, j)
-            }
-        }
-        // If there are more than one pixel with the specified color in the current row, fill the gap between the leftmost and rightmost pixels.
-        if number_points > 1 {
-            // Iterate through the positions to fill the ga
-            for k := positions[0] + 1; k < positions[len(positions)-1]; k++ {
-                ppm.data[i][k] = color
-
-            }
-        }
-    }
+	//draw the outline of the circle.
+	ppm.DrawCircle(center, radius, color)
+
+	// Iterate through each row of the image.
+	for i := 0; i < ppm.height; i++ {
+		var positions []int
+		var number_points int
+		// Iterate through each column of the image.
+		for j := 0; j < ppm.width; j++ {
+			// Check if the pixel at (i, j) has the specified color.
+			if ppm.At(j, i) == color {
+				number_points += 1
+				positions = append(positions, j)
+			}
+		}
+		// If there are more than one pixel with the specified color in the current row, fill the gap between the leftmost and rightmost pixels.
+		if number_points > 1 {
+			// Iterate through the positions to fill the ga
+			for k := positions[0] + 1; k < positions[len(positions)-1]; k++ {
+				ppm.Set(k, i, color)
+
+			}
+		}
+	}
 }
 
 // DrawTriangle draws a triangle
@@ -459,30 +534,30 @@ func (ppm *PPM) DrawTriangle(p1, p2, p3 Point, color Pixel) {
 
 // DrawFilledTriangle draws a triangle with the specified dimensions and color on the PPM image.
 func (ppm *PPM) DrawFilledTriangle(p1, p2, p3 Point, color Pixel) {
-    //draw the outline of the triangle.
-    ppm.DrawTriangle(p1, p2, p3, color)
-
-     // Iterate through each row of the image.
-    for i := 0; i < ppm.height; i++ {
-        var positions []int
-        var number_points int
-        // Check if the pixel at (i, j) has the specified color.
-        for j := 0; j < ppm.width; j++ {
-            if ppm.data[i][j] == color {
-                number_points += 1
-                positions = append(positions, j)
-            }
-        }
-
-        // If there are more than one pixel with the specified color in the current row, fill the gap between the leftmost and rightmost pixels.
-        if number_points > 1 {
-            // Iterate through the positions to fill the gap between the leftmost and rightmost pixels.
-            for k := positions[0] + 1; k < positions[len(positions)-1]; k++ {
-                ppm.data[i][k] = color
-
-            }
-        }
-    }
+	//draw the outline of the triangle.
+	ppm.DrawTriangle(p1, p2, p3, color)
+
+	// Iterate through each row of the image.
+	for i := 0; i < ppm.height; i++ {
+		var positions []int
+		var number_points int
+		// Check if the pixel at (i, j) has the specified color.
+		for j := 0; j < ppm.width; j++ {
+			if ppm.At(j, i) == color {
+				number_points += 1
+				positions = append(positions, j)
+			}
+		}
+
+		// If there are more than one pixel with the specified color in the current row, fill the gap between the leftmost and rightmost pixels.
+		if number_points > 1 {
+			// Iterate through the positions to fill the gap between the leftmost and rightmost pixels.
+			for k := positions[0] + 1; k < positions[len(positions)-1]; k++ {
+				ppm.Set(k, i, color)
+
+			}
+		}
+	}
 }
 
 // DrawRectangle draws a rectangle
@@ -533,37 +608,37 @@ func (ppm *PPM) DrawRectangle(p1 Point, width, height int, color Pixel) {
 
 // DrawRectangle draws a rectangle with the specified dimensions and color on the PPM image.
 func (ppm *PPM) DrawFilledRectangle(p1 Point, width, height int, color Pixel) {
-    // draw the outline of the rectangle
-    ppm.DrawRectangle(p1, width, height, color)
-
-    // Iterate through each row of the image.
-    for i := 0; i < ppm.height; i++ {
-        var positions []int
-        var number_points int
-        // Iterate through each column of the image.
-        for j := 0; j < ppm.width; j++ {
-            // Check if the pixel at (i, j) has the specified color
-            if ppm.data[i][j] == color {
-                number_points += 1
-                positions = append(positions, j)
-            }
-        }
-        //If there are more than one pixel with the specified color in the current row, fill the gap between the leftmost and rightmost pixels.
-        if number_points > 1 {
-            // Iterate through the positions to fill the gap between the leftmost and rightmost pixels.
-            for k := positions[0] + 1; k < positions[len(positions)-1]; k++ {
-                ppm.data[i][k] = color
-
-            }
-        }
-        if height > ppm.height && width > ppm.width {
-            for k := 0; k < ppm.width; k++ {
-                ppm.data[i][k] = color
-
-            }
-
-        }
-    }
+	// draw the outline of the rectangle
+	ppm.DrawRectangle(p1, width, height, color)
+
+	// Iterate through each row of the image.
+	for i := 0; i < ppm.height; i++ {
+		var positions []int
+		var number_points int
+		// Iterate through each column of the image.
+		for j := 0; j < ppm.width; j++ {
+			// Check if the pixel at (i, j) has the specified color
+			if ppm.At(j, i) == color {
+				number_points += 1
+				positions = append(positions, j)
+			}
+		}
+		//If there are more than one pixel with the specified color in the current row, fill the gap between the leftmost and rightmost pixels.
+		if number_points > 1 {
+			// Iterate through the positions to fill the gap between the leftmost and rightmost pixels.
+			for k := positions[0] + 1; k < positions[len(positions)-1]; k++ {
+				ppm.Set(k, i, color)
+
+			}
+		}
+		if height > ppm.height && width > ppm.width {
+			for k := 0; k < ppm.width; k++ {
+				ppm.Set(k, i, color)
+
+			}
+
+		}
+	}
 }
 
 // DrawPolygon draws a polygon.
@@ -585,26 +660,26 @@ func (ppm *PPM) DrawPolygon(points []Point, color Pixel) {
 
 // DrawFilledPolygon fills the specified polygon with the given color on the PPM image.
 func (ppm *PPM) DrawFilledPolygon(points []Point, color Pixel) {
-    //draw the outline of the polygon
-    ppm.DrawPolygon(points, color)
-
-    // Iterate through each row of the image.
-    for i := 0; i < ppm.height; i++ {
-        var positions []int
-        var number_points int
-        for j := 0; j < ppm.width; j++ {
-            // Check if the pixel at (i, j) has the specified color.
-            if ppm.data[i][j] == color {
-                number_points += 1
-                positions = append(positions, j)
-            }
-        }
-        //fills the space between the leftmost and rightmost pixels if there is more than one pixel with the color specified in the current line
-        if number_points > 1 {
-            for k := positions[0] + 1; k < positions[len(positions)-1]; k++ {
-                ppm.data[i][k] = color
-
-            }
-        }
-    }
+	//draw the outline of the polygon
+	ppm.DrawPolygon(points, color)
+
+	// Iterate through each row of the image.
+	for i := 0; i < ppm.height; i++ {
+		var positions []int
+		var number_points int
+		for j := 0; j < ppm.width; j++ {
+			// Check if the pixel at (i, j) has the specified color.
+			if ppm.At(j, i) == color {
+				number_points += 1
+				positions = append(positions, j)
+			}
+		}
+		//fills the space between the leftmost and rightmost pixels if there is more than one pixel with the color specified in the current line
+		if number_points > 1 {
+			for k := positions[0] + 1; k < positions[len(positions)-1]; k++ {
+				ppm.Set(k, i, color)
+
+			}
+		}
+	}
 }
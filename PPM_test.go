@@ -0,0 +1,81 @@
+package Netpbm
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Tests of PPM images: encoding and decoding both formats with 8- and 16-bit samples.
+
+// testPPM returns a PPM image with a pattern of samples up to max.
+func testPPM(t *testing.T, width, height int, max uint16, magicNumber string) *PPM {
+	t.Helper()
+	ppm, err := NewPPM(width, height, max, WithMagicNumber(magicNumber), WithComments("pattern"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := func(x, y, k int) uint16 {
+		return uint16((x*7919 + y*104729 + k*1299709) % (int(max) + 1))
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			ppm.Set16(x, y, Pixel16{sample(x, y, 0), sample(x, y, 1), sample(x, y, 2)})
+		}
+	}
+	return ppm
+}
+
+func TestPPMRoundTrip(t *testing.T) {
+	tests := []struct {
+		magicNumber   string
+		width, height int
+		max           uint16
+	}{
+		{"P3", 11, 3, 255},
+		{"P3", 11, 3, 65535},
+		{"P6", 11, 3, 255},
+		{"P6", 11, 3, 65535},
+		{"P6", 4, 4, 300},
+	}
+	for _, test := range tests {
+		ppm := testPPM(t, test.width, test.height, test.max, test.magicNumber)
+		var buffer bytes.Buffer
+		if err := EncodePPM(&buffer, ppm); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodePPM(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, ppm) {
+			t.Errorf("%s %dx%d max %d: decoded image differs from the encoded one", test.magicNumber, test.width, test.height, test.max)
+		}
+	}
+}
+
+func TestPPMRawSamples(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		samples []uint16
+		err     error
+	}{
+		{"8-bit", "P6 1 1 255\n\x01\x02\x03", []uint16{1, 2, 3}, nil},
+		{"16-bit big-endian", "P6 1 1 65535\n\x01\x02\x03\x04\xff\xff", []uint16{0x0102, 0x0304, 0xffff}, nil},
+		{"sample above max", "P6 1 1 200\n\x01\xc9\x03", nil, ErrSampleOutOfRange},
+		{"truncated", "P6 1 1 65535\n\x01\x02\x03\x04\xff", nil, ErrTruncated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ppm, err := DecodePPM(bytes.NewReader([]byte(test.data)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+			if err == nil && !reflect.DeepEqual(imageSamples(ppm), test.samples) {
+				t.Errorf("samples = %v, want %v", imageSamples(ppm), test.samples)
+			}
+		})
+	}
+}
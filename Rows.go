@@ -0,0 +1,405 @@
+package Netpbm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RowReader reads the pixels of a PBM, PGM or PPM image one row at a time,
+// so that images larger than memory can be processed with O(width) memory.
+type RowReader struct {
+	tok    *tokenizer
+	header header
+	y      int      // number of rows read so far
+	raw    []byte   // raw row, for P4, P5 and P6 images
+	row    []uint16 // samples of the current row, for PGM and PPM images
+}
+
+// NewRowReader reads the header of a PBM, PGM or PPM image from r and returns a RowReader for its pixels.
+func NewRowReader(r io.Reader) (*RowReader, error) {
+	// Read without any resource limit
+	return (&DecoderOptions{}).NewRowReader(r)
+}
+
+// NewRowReader reads the header of a PBM, PGM or PPM image from r within the limits of the options.
+// MaxPixels and MaxBytes apply to one row, the only one kept in memory.
+func (o *DecoderOptions) NewRowReader(r io.Reader) (*RowReader, error) {
+	// Read the magic number, the dimensions and the max value of any of the three formats
+	tok := o.newTokenizer(r, "Netpbm")
+	h, err := tok.readHeader("P1", "P2", "P3", "P4", "P5", "P6")
+	if err != nil {
+		return nil, err
+	}
+	tok.format = formatName(h.magicNumber)
+
+	tok.logHeader(h)
+
+	// Check the dimensions against the limits before going on, for the single row kept in memory
+	if err := o.checkRowLimits(tok, h); err != nil {
+		return nil, err
+	}
+
+	// Only one row is ever kept in memory
+	rr := &RowReader{tok: tok, header: h}
+	switch h.magicNumber {
+	case "P4":
+		rr.raw = make([]byte, (h.width+7)/8)
+	case "P5", "P6":
+		rr.raw = make([]byte, h.width*h.depth*sampleSize(uint16(h.max)))
+	}
+	if h.magicNumber != "P1" && h.magicNumber != "P4" {
+		rr.row = make([]uint16, h.width*h.depth)
+	}
+	if rr.raw != nil {
+		if err := tok.endHeader(); err != nil {
+			return nil, err
+		}
+	}
+	return rr, nil
+}
+
+// Config returns the header of the image being read.
+func (rr *RowReader) Config() Config {
+	return rr.header.config()
+}
+
+// ReadBitRow reads the next row of a PBM image into row, which must hold at least width values.
+// It returns io.EOF once every row has been read.
+func (rr *RowReader) ReadBitRow(row []bool) error {
+	if err := rr.next("bit", len(row), "P1", "P4"); err != nil {
+		return err
+	}
+
+	// Check if the PBM image format is "P1"
+	if rr.header.magicNumber == "P1" {
+		for j := 0; j < rr.header.width; j++ {
+			value, err := rr.tok.bit()
+			if err != nil {
+				return err
+			}
+			row[j] = value
+		}
+	} else {
+		if err := rr.tok.readFull(rr.raw); err != nil {
+			return err
+		}
+		// The most significant bit of each byte holds the leftmost pixel
+		for j := 0; j < rr.header.width; j++ {
+			row[j] = rr.raw[j/8]&(0x80>>uint(j%8)) != 0
+		}
+	}
+	return rr.done()
+}
+
+// ReadGrayRow reads the next row of a PGM image into row, which must hold at least width values.
+// It returns io.EOF once every row has been read.
+func (rr *RowReader) ReadGrayRow(row []uint16) error {
+	if err := rr.next("gray", len(row), "P2", "P5"); err != nil {
+		return err
+	}
+	if err := rr.readSamples(); err != nil {
+		return err
+	}
+	copy(row, rr.row)
+	return rr.done()
+}
+
+// ReadPixelRow reads the next row of a PPM image into row, which must hold at least width values.
+// Samples are scaled down to 8 bits when the max value is above 255, as PPM.At does.
+// It returns io.EOF once every row has been read.
+func (rr *RowReader) ReadPixelRow(row []Pixel) error {
+	if err := rr.next("pixel", len(row), "P3", "P6"); err != nil {
+		return err
+	}
+	if err := rr.readSamples(); err != nil {
+		return err
+	}
+	max := uint16(rr.header.max)
+	for j := 0; j < rr.header.width; j++ {
+		row[j] = Pixel{to8(rr.row[3*j], max), to8(rr.row[3*j+1], max), to8(rr.row[3*j+2], max)}
+	}
+	return rr.done()
+}
+
+// ReadPixel16Row reads the next row of a PPM image into row with the full samples.
+// It returns io.EOF once every row has been read.
+func (rr *RowReader) ReadPixel16Row(row []Pixel16) error {
+	if err := rr.next("pixel", len(row), "P3", "P6"); err != nil {
+		return err
+	}
+	if err := rr.readSamples(); err != nil {
+		return err
+	}
+	for j := 0; j < rr.header.width; j++ {
+		row[j] = Pixel16{rr.row[3*j], rr.row[3*j+1], rr.row[3*j+2]}
+	}
+	return rr.done()
+}
+
+// next checks that a row of the given kind and length can be read from the image.
+func (rr *RowReader) next(kind string, length int, magicNumbers ...string) error {
+	if rr.y == rr.header.height {
+		return io.EOF
+	}
+	valid := false
+	for _, magicNumber := range magicNumbers {
+		valid = valid || rr.header.magicNumber == magicNumber
+	}
+	if !valid {
+		return fmt.Errorf("netpbm: cannot read %s rows from a %s image", kind, rr.tok.format)
+	}
+	if length < rr.header.width {
+		return fmt.Errorf("netpbm: row of length %d is shorter than the width %d", length, rr.header.width)
+	}
+	return nil
+}
+
+// readSamples reads the samples of the next PGM or PPM row into rr.row.
+func (rr *RowReader) readSamples() error {
+	var err error
+	max := uint16(rr.header.max)
+
+	// Plain rows are made of decimal values, raw rows of one or two bytes per sample
+	if rr.raw == nil {
+		for k := range rr.row {
+			if rr.row[k], err = rr.tok.sample(rr.header.max); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := rr.tok.readFull(rr.raw); err != nil {
+		return err
+	}
+	for k := range rr.row {
+		if rr.row[k], err = rr.tok.rawSample(rr.raw, k, max); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// done counts the row that was just read.
+func (rr *RowReader) done() error {
+	rr.y++
+	if rr.y == rr.header.height {
+		rr.tok.logDone()
+	}
+	return nil
+}
+
+// formatName returns the name of the format of a PBM, PGM or PPM magic number.
+func formatName(magicNumber string) string {
+	switch magicNumber {
+	case "P1", "P4":
+		return "PBM"
+	case "P2", "P5":
+		return "PGM"
+	}
+	return "PPM"
+}
+
+// errRowsWritten is returned when more rows are written than the height of the image.
+var errRowsWritten = errors.New("netpbm: every row of the image has already been written")
+
+// RowWriter writes the pixels of a PBM, PGM or PPM image one row at a time,
+// so that images larger than memory can be produced with O(width) memory.
+// Close must be called once the rows are written, to check that none is missing.
+type RowWriter struct {
+	writer        io.Writer
+	magicNumber   string
+	width, height int
+	max           uint16
+	y             int       // number of rows written so far
+	buffer        []byte    // encoded raw row
+	plain         *plainRow // layout of plain rows, nil for raw images
+}
+
+// NewRowWriter writes the header of an image to w and returns a RowWriter for its pixels.
+// The magic number gives the format, from P1 to P6; the max value is ignored for PBM images.
+// The comments are written after the magic number.
+func NewRowWriter(w io.Writer, magicNumber string, width, height int, max uint16, comments ...string) (*RowWriter, error) {
+	// Encode with the layout of the specification
+	return (&EncoderOptions{}).NewRowWriter(w, magicNumber, width, height, max, comments...)
+}
+
+// NewRowWriter writes the header of an image to w and returns a RowWriter that lays out its pixels
+// as the options say. The Comments of the options, when not nil, replace the given comments.
+func (o *EncoderOptions) NewRowWriter(w io.Writer, magicNumber string, width, height int, max uint16, comments ...string) (*RowWriter, error) {
+	// Check the header before writing anything
+	switch magicNumber {
+	case "P1", "P2", "P3", "P4", "P5", "P6":
+	default:
+		return nil, fmt.Errorf("netpbm: invalid magic number %q", magicNumber)
+	}
+	if width < 1 || height < 1 {
+		return nil, fmt.Errorf("netpbm: invalid dimensions %dx%d", width, height)
+	}
+	pbm := magicNumber == "P1" || magicNumber == "P4"
+	if pbm {
+		max = 1
+	} else if max == 0 {
+		return nil, fmt.Errorf("netpbm: invalid max value 0")
+	}
+	rw := &RowWriter{writer: w, magicNumber: magicNumber, width: width, height: height, max: max}
+	if magicNumber == "P1" || magicNumber == "P2" || magicNumber == "P3" {
+		var err error
+		if rw.plain, err = o.newPlainRow(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Write the header, with a max value for PGM and PPM images only
+	if _, err := fmt.Fprintf(w, "%s\n", magicNumber); err != nil {
+		return nil, err
+	}
+	if err := writeComments(w, o.comments(comments)); err != nil {
+		return nil, err
+	}
+	var err error
+	if pbm {
+		_, err = fmt.Fprintf(w, "%d %d\n", width, height)
+	} else {
+		_, err = fmt.Fprintf(w, "%d %d\n%d\n", width, height, max)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// WriteBitRow writes the next row of a PBM image; true is black.
+func (rw *RowWriter) WriteBitRow(row []bool) error {
+	if err := rw.next("bit", len(row), "P1", "P4"); err != nil {
+		return err
+	}
+
+	// Check if the PBM image format is "P1"
+	if rw.magicNumber == "P1" {
+		for _, value := range row[:rw.width] {
+			if value {
+				rw.plain.appendUint(1)
+			} else {
+				rw.plain.appendUint(0)
+			}
+		}
+	} else {
+		// Pack 8 pixels per byte, most significant bit first, padding the row to a byte boundary
+		for k := 0; k < (rw.width+7)/8; k++ {
+			rw.buffer = append(rw.buffer, 0)
+		}
+		for j, value := range row[:rw.width] {
+			if value {
+				rw.buffer[j/8] |= 0x80 >> uint(j%8)
+			}
+		}
+	}
+	return rw.flush()
+}
+
+// WriteGrayRow writes the next row of a PGM image.
+func (rw *RowWriter) WriteGrayRow(row []uint16) error {
+	if err := rw.next("gray", len(row), "P2", "P5"); err != nil {
+		return err
+	}
+	for _, value := range row[:rw.width] {
+		if err := rw.appendSample(value); err != nil {
+			return err
+		}
+	}
+	return rw.flush()
+}
+
+// WritePixelRow writes the next row of a PPM image from 8-bit pixels,
+// which are scaled up when the max value is above 255, as PPM.Set does.
+func (rw *RowWriter) WritePixelRow(row []Pixel) error {
+	if err := rw.next("pixel", len(row), "P3", "P6"); err != nil {
+		return err
+	}
+	for _, pixel := range row[:rw.width] {
+		for _, value := range [3]uint8{pixel.R, pixel.G, pixel.B} {
+			if err := rw.appendSample(from8(value, rw.max)); err != nil {
+				return err
+			}
+		}
+	}
+	return rw.flush()
+}
+
+// WritePixel16Row writes the next row of a PPM image with the full samples.
+func (rw *RowWriter) WritePixel16Row(row []Pixel16) error {
+	if err := rw.next("pixel", len(row), "P3", "P6"); err != nil {
+		return err
+	}
+	for _, pixel := range row[:rw.width] {
+		for _, value := range [3]uint16{pixel.R, pixel.G, pixel.B} {
+			if err := rw.appendSample(value); err != nil {
+				return err
+			}
+		}
+	}
+	return rw.flush()
+}
+
+// Close reports an error wrapping ErrTruncated when fewer rows than the height
+// of the image were written, as the output would then hold a truncated image.
+// It does not close the underlying writer.
+func (rw *RowWriter) Close() error {
+	if rw.y < rw.height {
+		return fmt.Errorf("netpbm: only %d of the %d rows were written: %w", rw.y, rw.height, ErrTruncated)
+	}
+	return nil
+}
+
+// next checks that a row of the given kind and length can be written to the image.
+func (rw *RowWriter) next(kind string, length int, magicNumbers ...string) error {
+	if rw.y == rw.height {
+		return errRowsWritten
+	}
+	valid := false
+	for _, magicNumber := range magicNumbers {
+		valid = valid || rw.magicNumber == magicNumber
+	}
+	if !valid {
+		return fmt.Errorf("netpbm: cannot write %s rows to a %s image", kind, formatName(rw.magicNumber))
+	}
+	if length < rw.width {
+		return fmt.Errorf("netpbm: row of length %d is shorter than the width %d", length, rw.width)
+	}
+	// Drop whatever a failed call may have left
+	rw.buffer = rw.buffer[:0]
+	if rw.plain != nil {
+		rw.plain.buffer, rw.plain.length = rw.plain.buffer[:0], 0
+	}
+	return nil
+}
+
+// appendSample encodes a sample of a PGM or PPM row, checking it against the max value.
+func (rw *RowWriter) appendSample(value uint16) error {
+	// Samples above the max value would make the image invalid
+	if value > rw.max {
+		return fmt.Errorf("netpbm: sample %d exceeds the max value %d", value, rw.max)
+	}
+	if rw.plain != nil {
+		rw.plain.appendUint(uint64(value))
+	} else {
+		rw.buffer = appendSample(rw.buffer, value, rw.max)
+	}
+	return nil
+}
+
+// flush writes the encoded row and counts it.
+func (rw *RowWriter) flush() error {
+	// Plain rows end with a newline, as in the whole-image encoders
+	if rw.plain != nil {
+		rw.plain.endRow()
+		if err := rw.plain.flush(rw.writer); err != nil {
+			return err
+		}
+	} else if _, err := rw.writer.Write(rw.buffer); err != nil {
+		return err
+	}
+	rw.y++
+	return nil
+}
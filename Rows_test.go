@@ -0,0 +1,211 @@
+package Netpbm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// Tests of RowReader and RowWriter: rows must match the whole-image encoders and decoders.
+
+// writeRows writes the image row by row and returns the encoded bytes.
+func writeRows(t *testing.T, img Image, magicNumber string, max uint16) []byte {
+	t.Helper()
+	width, height := img.Size()
+	var buffer bytes.Buffer
+	rw, err := NewRowWriter(&buffer, magicNumber, width, height, max, "pattern")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < height; y++ {
+		switch img := img.(type) {
+		case *PBM:
+			row := make([]bool, width)
+			for x := range row {
+				row[x] = img.At(x, y)
+			}
+			err = rw.WriteBitRow(row)
+		case *PGM:
+			row := make([]uint16, width)
+			for x := range row {
+				row[x] = img.At16(x, y)
+			}
+			err = rw.WriteGrayRow(row)
+		case *PPM:
+			row := make([]Pixel16, width)
+			for x := range row {
+				row[x] = img.At16(x, y)
+			}
+			err = rw.WritePixel16Row(row)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// readRows reads an image row by row and returns its samples, as imageSamples does.
+// It checks that io.EOF is returned after the last row.
+func readRows(t *testing.T, data []byte) []uint16 {
+	t.Helper()
+	rr, err := NewRowReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := rr.Config()
+	var samples []uint16
+	read := func() error {
+		switch config.MagicNumber {
+		case "P1", "P4":
+			row := make([]bool, config.Width)
+			if err := rr.ReadBitRow(row); err != nil {
+				return err
+			}
+			for _, value := range row {
+				if value {
+					samples = append(samples, 1)
+				} else {
+					samples = append(samples, 0)
+				}
+			}
+		case "P2", "P5":
+			row := make([]uint16, config.Width)
+			if err := rr.ReadGrayRow(row); err != nil {
+				return err
+			}
+			samples = append(samples, row...)
+		default:
+			row := make([]Pixel16, config.Width)
+			if err := rr.ReadPixel16Row(row); err != nil {
+				return err
+			}
+			for _, pixel := range row {
+				samples = append(samples, pixel.R, pixel.G, pixel.B)
+			}
+		}
+		return nil
+	}
+	for y := 0; y < config.Height; y++ {
+		if err := read(); err != nil {
+			t.Fatalf("row %d: %v", y, err)
+		}
+	}
+	if err := read(); err != io.EOF {
+		t.Errorf("reading past the last row: error = %v, want io.EOF", err)
+	}
+	return samples
+}
+
+func TestRowRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		magicNumber string
+		max         uint16
+	}{
+		{"P1", "P1", 1},
+		{"P4", "P4", 1},
+		{"P2 8-bit", "P2", 255},
+		{"P5 8-bit", "P5", 255},
+		{"P5 16-bit", "P5", 65535},
+		{"P3 8-bit", "P3", 255},
+		{"P6 8-bit", "P6", 255},
+		{"P6 16-bit", "P6", 65535},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Encode the same image as a whole, to compare the bytes
+			var img Image
+			var buffer bytes.Buffer
+			var err error
+			switch test.magicNumber {
+			case "P1", "P4":
+				pbm := testPBM(t, 13, 3, test.magicNumber)
+				img, err = pbm, EncodePBM(&buffer, pbm)
+			case "P2", "P5":
+				pgm := testPGM(t, 13, 3, test.max, test.magicNumber)
+				img, err = pgm, EncodePGM(&buffer, pgm)
+			default:
+				ppm := testPPM(t, 13, 3, test.max, test.magicNumber)
+				img, err = ppm, EncodePPM(&buffer, ppm)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data := writeRows(t, img, test.magicNumber, test.max)
+			if !bytes.Equal(data, buffer.Bytes()) {
+				t.Errorf("rows encoded as %q, want %q", data, buffer.Bytes())
+			}
+			if samples, want := readRows(t, data), imageSamples(img); !reflect.DeepEqual(samples, want) {
+				t.Errorf("samples = %v, want %v", samples, want)
+			}
+		})
+	}
+}
+
+func TestReadPixelRowScaling(t *testing.T) {
+	// 16-bit samples are scaled down to 8 bits, as PPM.At does
+	data := "P6 2 1 65535\n\xff\xff\x00\x00\x80\x00\x01\x00\x00\x00\xff\xff"
+	rr, err := NewRowReader(bytes.NewReader([]byte(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := make([]Pixel, 2)
+	if err := rr.ReadPixelRow(row); err != nil {
+		t.Fatal(err)
+	}
+	ppm, err := DecodePPM(bytes.NewReader([]byte(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Pixel{ppm.At(0, 0), ppm.At(1, 0)}; !reflect.DeepEqual(row, want) {
+		t.Errorf("row = %v, want %v", row, want)
+	}
+}
+
+func TestRowWriterErrors(t *testing.T) {
+	var buffer bytes.Buffer
+	rw, err := NewRowWriter(&buffer, "P5", 2, 2, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows of the wrong kind, too short or with samples above the max value are refused
+	if err := rw.WriteBitRow([]bool{true, false}); err == nil {
+		t.Error("writing a bit row to a PGM image succeeded")
+	}
+	if err := rw.WriteGrayRow([]uint16{1}); err == nil {
+		t.Error("writing a short row succeeded")
+	}
+	if err := rw.WriteGrayRow([]uint16{1, 256}); err == nil {
+		t.Error("writing a sample above the max value succeeded")
+	}
+
+	// Closing with missing rows reports a truncated image
+	if err := rw.WriteGrayRow([]uint16{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Close(); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Close after 1 of 2 rows: error = %v, want ErrTruncated", err)
+	}
+
+	// Once every row is written, Close succeeds and more rows are refused
+	if err := rw.WriteGrayRow([]uint16{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Errorf("Close after every row: %v", err)
+	}
+	if err := rw.WriteGrayRow([]uint16{5, 6}); !errors.Is(err, errRowsWritten) {
+		t.Errorf("writing a third row: error = %v, want errRowsWritten", err)
+	}
+	if want := "P5\n2 2\n255\n\x01\x02\x03\x04"; buffer.String() != want {
+		t.Errorf("encoded %q, want %q", buffer.String(), want)
+	}
+}
@@ -0,0 +1,98 @@
+package Netpbm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// saveFile writes a file atomically: encode writes the image, which the encoders
+// buffer, into a temporary file of the same directory, which is synced to disk and
+// then renamed to filename. A crash or a full disk thus never leaves a truncated
+// file under filename.
+//
+// Once the rename succeeded, filename holds the new image; a failure to sync the
+// directory afterwards only means the rename may be lost in a crash. It is then
+// reported with an error saying the file was saved, and nothing is removed.
+func saveFile(filename string, encode func(w io.Writer) error) (err error) {
+	// Create the temporary file next to the destination, so that the rename stays on one file system
+	file, err := createTemp(filename)
+	if err != nil {
+		return err
+	}
+	// Remove the temporary file if anything goes wrong before it is renamed
+	renamed := false
+	defer func() {
+		if err != nil && !renamed {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	// Keep the permissions of the file being replaced; a new file keeps those
+	// os.Create would give, 0666 less the umask, applied when it was created
+	if info, statErr := os.Stat(filename); statErr == nil {
+		if err := file.Chmod(info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	// Encode the image, then push it to disk before it takes the place of the destination
+	if err := encode(file); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return err
+	}
+	renamed = true
+
+	// The rename itself is only durable once the directory holding it is synced
+	if err := syncDir(filepath.Dir(filename)); err != nil {
+		return fmt.Errorf("netpbm: %s was saved but its directory could not be synced: %w", filename, err)
+	}
+	return nil
+}
+
+// createTemp creates a new temporary file next to filename. Unlike os.CreateTemp,
+// which always uses 0600, it lets the umask decide the permissions, as os.Create does.
+func createTemp(filename string) (*os.File, error) {
+	dir, base := filepath.Split(filename)
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, "."+base+"."+strconv.FormatInt(rand.Int63(), 36)+".tmp")
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		// Pick another name if this one is already taken
+		if errors.Is(err, fs.ErrExist) && try < 100 {
+			continue
+		}
+		return file, err
+	}
+}
+
+// syncDir flushes the entries of a directory to disk. Windows refuses to
+// sync a directory, so nothing is done there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
@@ -0,0 +1,101 @@
+package Netpbm
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// Tests of Save: images replace files atomically, through a temporary file of the same directory.
+
+// checkDir reports an error unless the directory holds exactly the given files.
+func checkDir(t *testing.T, dir string, want ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("directory holds %q, want %q", names, want)
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "image.pgm")
+	pgm := testPGM(t, 5, 3, 255, "P5")
+	if err := pgm.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ReadPGM(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, pgm) {
+		t.Error("saved image differs from the original one")
+	}
+	checkDir(t, dir, "image.pgm")
+}
+
+func TestSaveKeepsPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no Unix permissions")
+	}
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "image.pbm")
+	if err := os.WriteFile(filename, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filename, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := testPBM(t, 9, 2, "P4").Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0640 {
+		t.Errorf("permissions = %v, want %v", perm, fs.FileMode(0640))
+	}
+}
+
+func TestSaveMissingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	err := testPBM(t, 9, 2, "P4").Save(filepath.Join(dir, "missing", "image.pbm"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error = %v, want fs.ErrNotExist", err)
+	}
+	checkDir(t, dir)
+}
+
+func TestSaveEncodeError(t *testing.T) {
+	// A failed encoding leaves the existing file as it was, and no temporary file
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "image.ppm")
+	if err := os.WriteFile(filename, []byte("previous"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	ppm := testPPM(t, 2, 2, 255, "P6")
+	ppm.SetMagicNumber("P9")
+	if err := ppm.Save(filename); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("error = %v, want ErrBadMagic", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "previous" {
+		t.Errorf("file holds %q after a failed save, want %q", data, "previous")
+	}
+	checkDir(t, dir, "image.ppm")
+}
@@ -0,0 +1,83 @@
+package Netpbm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Decoder reads successive PBM, PGM and PPM images from a single stream,
+// such as a file holding several images back to back or the output of
+// ffmpeg's image2pipe.
+type Decoder struct {
+	reader  *bufio.Reader
+	options DecoderOptions
+}
+
+// NewDecoder creates a Decoder reading images from r.
+func NewDecoder(r io.Reader) *Decoder {
+	// Decode without any resource limit
+	return (&DecoderOptions{}).NewDecoder(r)
+}
+
+// NewDecoder creates a Decoder reading images from r within the limits of the options.
+func (o *DecoderOptions) NewDecoder(r io.Reader) *Decoder {
+	// Every image is read through the same buffered reader, so that nothing
+	// past the end of one image is lost before the next one is decoded.
+	// This relies on bufio.NewReader returning its argument unchanged when it is
+	// already a *bufio.Reader at least as large as the default size, which is
+	// what Decode and newTokenizer get from Next: they then share this buffer
+	// instead of reading ahead into buffers of their own.
+	return &Decoder{reader: bufio.NewReader(r), options: *o}
+}
+
+// Next decodes the next image of the stream. The concrete type of the result is *PBM, *PGM or *PPM.
+// Next returns io.EOF once the stream holds nothing but whitespace.
+func (d *Decoder) Next() (Image, error) {
+	// Skip the whitespace that plain images may leave after their last sample
+	for {
+		c, err := d.reader.ReadByte()
+		if err != nil {
+			return nil, err // io.EOF when the stream ends between two images
+		}
+		if !isSpace(c) {
+			d.reader.UnreadByte()
+			break
+		}
+	}
+
+	// Decode the image that starts here; the reader is left on the byte that follows it,
+	// as long as d.reader keeps the default size (see NewDecoder)
+	return d.options.Decode(d.reader)
+}
+
+// Encoder writes successive PBM, PGM and PPM images to a single stream.
+type Encoder struct {
+	writer  io.Writer
+	options EncoderOptions
+}
+
+// NewEncoder creates an Encoder writing images to w.
+func NewEncoder(w io.Writer) *Encoder {
+	// Encode with the layout of the specification
+	return (&EncoderOptions{}).NewEncoder(w)
+}
+
+// NewEncoder creates an Encoder writing images to w with the layout of the options.
+func (o *EncoderOptions) NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{writer: w, options: *o}
+}
+
+// Encode writes an image right after the previous one, in its own format.
+func (e *Encoder) Encode(img Image) error {
+	// Images are concatenated as they are, which the Netpbm specification allows
+	switch img := img.(type) {
+	case *PBM:
+		return e.options.EncodePBM(e.writer, img)
+	case *PGM:
+		return e.options.EncodePGM(e.writer, img)
+	case *PPM:
+		return e.options.EncodePPM(e.writer, img)
+	}
+	return fmt.Errorf("netpbm: cannot encode image of type %T", img)
+}
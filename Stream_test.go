@@ -0,0 +1,91 @@
+package Netpbm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// Tests of Decoder and Encoder: several images back to back in a single stream.
+
+// testStream returns a P5, a P3 and a P4 image, and their concatenation.
+func testStream(t *testing.T) ([]Image, []byte) {
+	t.Helper()
+	images := []Image{
+		testPGM(t, 5, 3, 255, "P5"),
+		testPPM(t, 4, 2, 65535, "P3"),
+		testPBM(t, 9, 2, "P4"),
+	}
+	var buffer bytes.Buffer
+	encoder := NewEncoder(&buffer)
+	for _, img := range images {
+		if err := encoder.Encode(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return images, buffer.Bytes()
+}
+
+func TestDecoderNext(t *testing.T) {
+	images, data := testStream(t)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"concatenated", data},
+		// Plain images may be followed by any amount of whitespace, so may the stream
+		{"trailing whitespace", append(append([]byte{}, data...), " \n\t\n"...)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoder := NewDecoder(bytes.NewReader(test.data))
+			for i, want := range images {
+				img, err := decoder.Next()
+				if err != nil {
+					t.Fatalf("image %d: %v", i, err)
+				}
+				if !reflect.DeepEqual(img, want) {
+					t.Errorf("image %d: decoded %T differs from the encoded one", i, img)
+				}
+			}
+			if _, err := decoder.Next(); err != io.EOF {
+				t.Errorf("after the last image: error = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestDecoderPlainTrailingWhitespace(t *testing.T) {
+	// The whitespace after the last sample of a plain image is not part of the next one
+	data := "P2\n2 1\n9\n1 2\n\n  \nP3 1 1 9 1 2 3 \n"
+	decoder := NewDecoder(bytes.NewReader([]byte(data)))
+	var samples [][]uint16
+	for {
+		img, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, imageSamples(img))
+	}
+	if want := [][]uint16{{1, 2}, {1, 2, 3}}; !reflect.DeepEqual(samples, want) {
+		t.Errorf("samples = %v, want %v", samples, want)
+	}
+}
+
+func TestDecoderTruncatedLastImage(t *testing.T) {
+	images, data := testStream(t)
+	decoder := NewDecoder(bytes.NewReader(data[:len(data)-1]))
+	for i := range images[:len(images)-1] {
+		if _, err := decoder.Next(); err != nil {
+			t.Fatalf("image %d: %v", i, err)
+		}
+	}
+	if _, err := decoder.Next(); !errors.Is(err, ErrTruncated) {
+		t.Errorf("truncated image: error = %v, want ErrTruncated", err)
+	}
+}
@@ -0,0 +1,380 @@
+package Netpbm
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// tokenizer splits the header and plain raster of a Netpbm image into tokens.
+// As the specification allows, whitespace and '#' comments may appear between
+// any two tokens, and tokens may be wrapped over lines in any way.
+type tokenizer struct {
+	reader    *bufio.Reader
+	format    string   // format reported in errors
+	offset    int64    // number of bytes consumed so far
+	line      int      // current line, starting at 1
+	start     int64    // offset of the token being read
+	startLine int      // line of the token being read
+	comments  []string // text of the comments read so far, without the '#'
+	logger    *slog.Logger
+}
+
+// header holds the values read from the header of an image.
+type header struct {
+	magicNumber   string
+	width, height int
+	depth         int     // samples per pixel
+	max           int     // 1 for PBM images and 0 for PFM images
+	tupleType     string  // PAM images only
+	scale         float64 // PFM images only
+	comments      []string
+}
+
+// pixelSize returns the number of bytes taken in memory by one decoded pixel,
+// and false when that number does not fit in an int64.
+func (h header) pixelSize() (int64, bool) {
+	var sampleBytes int64
+	switch h.magicNumber {
+	case "P1", "P4":
+		return 1, true // at most one byte, as rows are packed 8 pixels per byte
+	case "PF", "Pf":
+		sampleBytes = 4 // float32 samples
+	case "P7":
+		sampleBytes = 2 // uint16 samples
+	default:
+		sampleBytes = int64(sampleSize(uint16(h.max))) // raw samples of one or two bytes
+	}
+	return multiply(int64(h.depth), sampleBytes)
+}
+
+// imageSize returns the number of bytes taken in memory by the decoded pixels,
+// and false when that number does not fit in an int64.
+func (h header) imageSize() (int64, bool) {
+	// Rows are packed 8 pixels per byte and padded to a byte boundary
+	rowSize, ok := (int64(h.width)+7)/8, true
+	if h.magicNumber != "P1" && h.magicNumber != "P4" {
+		pixelSize, pixelOK := h.pixelSize()
+		rowSize, ok = multiply(int64(h.width), pixelSize)
+		ok = ok && pixelOK
+	}
+	size, sizeOK := multiply(rowSize, int64(h.height))
+	return size, ok && sizeOK
+}
+
+// multiply returns a × b, and false when either is negative or the product does not fit in an int64.
+func multiply(a, b int64) (int64, bool) {
+	if a < 0 || b < 0 {
+		return 0, false
+	}
+	hi, lo := bits.Mul64(uint64(a), uint64(b))
+	return int64(lo), hi == 0 && lo <= math.MaxInt64
+}
+
+// newTokenizer creates a tokenizer reading an image of the given format from r.
+func newTokenizer(r io.Reader, format string) *tokenizer {
+	return &tokenizer{reader: bufio.NewReader(r), format: format, line: 1, startLine: 1}
+}
+
+// newTokenizer creates a tokenizer that reports its progress to the logger of the options, if any.
+func (o *DecoderOptions) newTokenizer(r io.Reader, format string) *tokenizer {
+	t := newTokenizer(r, format)
+	t.logger = o.Logger
+	return t
+}
+
+// log emits a debug event when a logger is set.
+func (t *tokenizer) log(msg string, args ...any) {
+	if t.logger != nil {
+		t.logger.Debug(msg, args...)
+	}
+}
+
+// logHeader emits the events describing the header that was just read.
+func (t *tokenizer) logHeader(h header) {
+	t.log("netpbm: format detected", "format", t.format, "magic", h.magicNumber)
+	t.log("netpbm: dimensions", "width", h.width, "height", h.height, "depth", h.depth, "max", h.max)
+}
+
+// logDone emits the event closing the decoding of an image.
+func (t *tokenizer) logDone() {
+	t.log("netpbm: image decoded", "format", t.format, "bytes", t.offset, "comments", len(t.comments))
+}
+
+// comment records the text of a comment, dropping the space that usually follows the '#'.
+func (t *tokenizer) comment(text string) {
+	t.log("netpbm: comment read", "line", t.startLine, "offset", t.start)
+	t.comments = append(t.comments, strings.TrimPrefix(strings.TrimRight(text, "\r"), " "))
+}
+
+// isSpace reports whether c is whitespace as defined by the Netpbm specification.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
+
+// mark records the current position as the start of the next token.
+func (t *tokenizer) mark() {
+	t.start, t.startLine = t.offset, t.line
+}
+
+// fail returns a *ParseError for the token being read.
+func (t *tokenizer) fail(field, value string, err error) error {
+	return &ParseError{Format: t.format, Offset: t.start, Line: t.startLine, Field: field, Value: value, Err: err}
+}
+
+// readError turns an end of file into a truncation *ParseError and returns other read errors as they are.
+func (t *tokenizer) readError(field string, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		t.mark()
+		return t.fail(field, "", ErrTruncated)
+	}
+	return err
+}
+
+// readByte reads one byte and keeps track of the position in the stream.
+func (t *tokenizer) readByte() (byte, error) {
+	c, err := t.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	t.offset++
+	if c == '\n' {
+		t.line++
+	}
+	return c, nil
+}
+
+// unreadByte puts back the last byte returned by readByte.
+func (t *tokenizer) unreadByte(c byte) {
+	t.reader.UnreadByte()
+	t.offset--
+	if c == '\n' {
+		t.line--
+	}
+}
+
+// skipSpace skips whitespace and records comments, which run from '#' to the end of the line.
+func (t *tokenizer) skipSpace() error {
+	for {
+		c, err := t.readByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case c == '#':
+			// Read the rest of the comment line
+			t.start, t.startLine = t.offset-1, t.line
+			var text []byte
+			for {
+				if c, err = t.readByte(); err != nil {
+					break
+				}
+				if c == '\n' || c == '\r' {
+					break
+				}
+				text = append(text, c)
+			}
+			t.comment(string(text))
+			if err != nil {
+				return err
+			}
+		case !isSpace(c):
+			t.unreadByte(c)
+			return nil
+		}
+	}
+}
+
+// token returns the next run of characters that are neither whitespace nor part of a comment.
+// The delimiter that ends the token is left unread.
+func (t *tokenizer) token(field string) (string, error) {
+	if err := t.skipSpace(); err != nil {
+		return "", t.readError(field, err)
+	}
+	t.mark()
+	var token []byte
+	for {
+		c, err := t.readByte()
+		if err == io.EOF {
+			// The token runs until the end of the stream
+			return string(token), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if isSpace(c) || c == '#' {
+			t.unreadByte(c)
+			return string(token), nil
+		}
+		token = append(token, c)
+	}
+}
+
+// magic reads the two-byte magic number that starts every Netpbm image.
+func (t *tokenizer) magic() (string, error) {
+	t.mark()
+	var magic [2]byte
+	n, err := io.ReadFull(t.reader, magic[:])
+	t.offset += int64(n)
+	if err != nil {
+		return "", t.readError("magic number", err)
+	}
+	return string(magic[:]), nil
+}
+
+// number reads the next token as a non-negative decimal integer.
+// Tokens that are not numbers are reported as ErrSyntax.
+func (t *tokenizer) number(field string) (int, error) {
+	token, err := t.token(field)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.Atoi(token)
+	if err != nil || value < 0 || token[0] == '+' {
+		return 0, t.fail(field, token, ErrSyntax)
+	}
+	return value, nil
+}
+
+// sample reads the next plain raster value and checks that it does not exceed max.
+func (t *tokenizer) sample(max int) (uint16, error) {
+	value, err := t.number("raster")
+	if err != nil {
+		return 0, err
+	}
+	if value > max {
+		return 0, t.fail("raster", strconv.Itoa(value), ErrSampleOutOfRange)
+	}
+	return uint16(value), nil
+}
+
+// bit reads the next pixel of a plain PBM raster, where digits need not be separated by whitespace.
+func (t *tokenizer) bit() (bool, error) {
+	if err := t.skipSpace(); err != nil {
+		return false, t.readError("raster", err)
+	}
+	t.mark()
+	c, err := t.readByte()
+	if err != nil {
+		return false, t.readError("raster", err)
+	}
+	if c != '0' && c != '1' {
+		return false, t.fail("raster", string(c), ErrSyntax)
+	}
+	return c == '1', nil
+}
+
+// endHeader consumes the single whitespace character that separates the header from a raw raster.
+func (t *tokenizer) endHeader() error {
+	t.mark()
+	c, err := t.readByte()
+	if err != nil {
+		return t.readError("raster", err)
+	}
+	if !isSpace(c) {
+		return t.fail("header", string(c), ErrSyntax)
+	}
+	return nil
+}
+
+// readFull reads exactly len(buf) bytes of raw raster data.
+func (t *tokenizer) readFull(buf []byte) error {
+	t.mark()
+	n, err := io.ReadFull(t.reader, buf)
+	t.offset += int64(n)
+	if err != nil {
+		return t.readError("raster", err)
+	}
+	return nil
+}
+
+// rawSample decodes the j-th sample of a row read by readFull and checks that it does not exceed max.
+func (t *tokenizer) rawSample(row []byte, j int, max uint16) (uint16, error) {
+	sample := readSample(row, j, max)
+	if sample > max {
+		// Point at the sample inside the row
+		t.start += int64(j * sampleSize(max))
+		return 0, t.fail("raster", strconv.Itoa(int(sample)), ErrSampleOutOfRange)
+	}
+	return sample, nil
+}
+
+// readLine reads a single line without its line terminator, for the line-based PAM and PFM headers.
+func (t *tokenizer) readLine(field string) (string, error) {
+	t.mark()
+	line, err := t.reader.ReadString('\n')
+	t.offset += int64(len(line))
+	if err != nil {
+		// A last line without a terminating newline is still a valid line
+		if err == io.EOF && len(line) > 0 {
+			return strings.TrimRight(line, "\r"), nil
+		}
+		return "", t.readError(field, err)
+	}
+	t.line++
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readHeader reads the magic number, the dimensions and, except for PBM images, the max value.
+// The magic number must be one of magicNumbers.
+func (t *tokenizer) readHeader(magicNumbers ...string) (header, error) {
+	var h header
+	var err error
+
+	// Read the magic number to determine the format
+	if h.magicNumber, err = t.magic(); err != nil {
+		return h, err
+	}
+	valid := false
+	for _, magicNumber := range magicNumbers {
+		valid = valid || h.magicNumber == magicNumber
+	}
+	if !valid {
+		return h, t.fail("magic number", h.magicNumber, ErrBadMagic)
+	}
+
+	// Read dimensions (width and height), which must not be zero
+	if h.width, err = t.number("width"); err != nil {
+		return h, t.dimensionError(err)
+	}
+	if h.width == 0 {
+		return h, t.fail("width", "0", ErrBadDimensions)
+	}
+	if h.height, err = t.number("height"); err != nil {
+		return h, t.dimensionError(err)
+	}
+	if h.height == 0 {
+		return h, t.fail("height", "0", ErrBadDimensions)
+	}
+
+	// PPM images have three samples per pixel, the others one
+	h.depth = 1
+	if h.magicNumber == "P3" || h.magicNumber == "P6" {
+		h.depth = 3
+	}
+
+	// PBM images have no max value, the others have one between 1 and 65535
+	h.max = 1
+	if h.magicNumber != "P1" && h.magicNumber != "P4" {
+		if h.max, err = t.number("max value"); err != nil {
+			return h, err
+		}
+		if h.max < 1 || h.max > 65535 {
+			return h, t.fail("max value", strconv.Itoa(h.max), ErrBadMaxValue)
+		}
+	}
+	h.comments = t.comments
+	return h, nil
+}
+
+// dimensionError reports dimensions that are too large for an int as ErrBadDimensions rather than ErrSyntax.
+func (t *tokenizer) dimensionError(err error) error {
+	if e, ok := err.(*ParseError); ok && e.Err == ErrSyntax && strings.Trim(e.Value, "0123456789") == "" {
+		e.Err = ErrBadDimensions
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package Netpbm
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tests of the tokenizer through the PBM, PGM and PPM decoders: the layouts the
+// specification allows, wrapped over lines in any way.
+
+func TestDecodeLayouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		samples  []uint16 // samples of the image, row by row
+		comments []string
+	}{
+		{"P1 one row per line", "P1\n3 2\n1 0 1\n0 1 0\n", []uint16{1, 0, 1, 0, 1, 0}, nil},
+		{"P1 digits without separators", "P1\n3 2\n101\n010\n", []uint16{1, 0, 1, 0, 1, 0}, nil},
+		{"P1 on a single line", "P1 3 2 101010", []uint16{1, 0, 1, 0, 1, 0}, nil},
+		{"P2 comments anywhere in the header", "P2 # magic\n#c1\n3\n#c2\n2\n# c3\n255\n0 1 2 3 4 5\n",
+			[]uint16{0, 1, 2, 3, 4, 5}, []string{"magic", "c1", "c2", "c3"}},
+		{"P2 comments in the raster skipped", "P2 3 2 255\n0 1\n# in the raster\n2 3 4 # after a value\n5",
+			[]uint16{0, 1, 2, 3, 4, 5}, nil},
+		{"P2 rows wrapped over lines", "P2\n3 2 255\n0\n1\n2 3\n4 5", []uint16{0, 1, 2, 3, 4, 5}, nil},
+		{"P3 CRLF line ends", "P3\r\n# crlf\r\n2 1\r\n255\r\n1 2 3 4 5 6\r\n", []uint16{1, 2, 3, 4, 5, 6}, []string{"crlf"}},
+		{"P3 tabs and 16-bit samples", "P3\t1\t1\t65535\t65535\t0\t256", []uint16{65535, 0, 256}, nil},
+		{"P4 comment before the raster", "P4\n# hi\n9 1\n\xff\x80", []uint16{1, 1, 1, 1, 1, 1, 1, 1, 1}, []string{"hi"}},
+		{"P5 16-bit samples", "P5 2 1 65535\n\x01\x02\xff\xff", []uint16{0x0102, 0xffff}, nil},
+		{"P6 raster starting with whitespace bytes", "P6 1 1 255 \x0a\x20\x0c", []uint16{0x0a, 0x20, 0x0c}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := Decode(strings.NewReader(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if samples := imageSamples(img); !reflect.DeepEqual(samples, test.samples) {
+				t.Errorf("samples = %v, want %v", samples, test.samples)
+			}
+			var comments []string
+			switch img := img.(type) {
+			case *PBM:
+				comments = img.Comments()
+			case *PGM:
+				comments = img.Comments()
+			case *PPM:
+				comments = img.Comments()
+			}
+			if len(comments) != 0 || len(test.comments) != 0 {
+				if !reflect.DeepEqual(comments, test.comments) {
+					t.Errorf("comments = %q, want %q", comments, test.comments)
+				}
+			}
+		})
+	}
+}
+
+// imageSamples returns the samples of a PBM, PGM or PPM image row by row, with 1 for black PBM pixels.
+func imageSamples(img Image) []uint16 {
+	var samples []uint16
+	width, height := img.Size()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			switch img := img.(type) {
+			case *PBM:
+				if img.At(x, y) {
+					samples = append(samples, 1)
+				} else {
+					samples = append(samples, 0)
+				}
+			case *PGM:
+				samples = append(samples, img.At16(x, y))
+			case *PPM:
+				pixel := img.At16(x, y)
+				samples = append(samples, pixel.R, pixel.G, pixel.B)
+			}
+		}
+	}
+	return samples
+}
+
+func TestLongPlainRows(t *testing.T) {
+	// Rows far longer than the 64 KiB a bufio.Scanner line could hold
+	const width = 20000
+	var data strings.Builder
+	data.WriteString("P3\n20000 2\n65535\n")
+	for y := 0; y < 2; y++ {
+		for x := 0; x < width; x++ {
+			data.WriteString("65535 1234 65535 ")
+		}
+		data.WriteString("\n")
+	}
+	if data.Len() < 2*64<<10 {
+		t.Fatalf("rows of %d bytes are not long enough", data.Len()/2)
+	}
+	ppm, err := DecodePPM(strings.NewReader(data.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []int{0, width / 2, width - 1} {
+		if pixel := ppm.At16(x, 1); pixel != (Pixel16{65535, 1234, 65535}) {
+			t.Errorf("pixel %d = %v", x, pixel)
+		}
+	}
+
+	// The same rows cut in the middle report a truncated image instead of zeroed pixels
+	cut := data.String()[:data.Len()-data.Len()/4]
+	if _, err := DecodePPM(strings.NewReader(cut)); !errors.Is(err, ErrTruncated) {
+		t.Errorf("error = %v, want %v", err, ErrTruncated)
+	}
+}